@@ -0,0 +1,121 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// artifactGroup deduplicates concurrent installs of the same artifact within the process.
+var artifactGroup singleflight.Group
+
+// ensureArtifactE makes sure the artifact at target exists beneath the cache root, creating it
+// with install if it doesn't. Parallel tests and other processes sharing the cache can safely
+// ask for the same artifact at once: installs are deduplicated within the process, serialised
+// across processes with a lock file, and the artifact only appears at target once it is
+// complete. If the caller whose install others are waiting on gives up because its ctx is done,
+// the install is tried again by one of the callers still waiting.
+//
+// install is given a new temporary directory beneath root to work in, and returns the path of
+// the finished artifact within it, which is then renamed into place. It should stop when ctx is
+// done.
+func ensureArtifactE(ctx context.Context, root, target string, install func(tmpDir string) (string, error)) error {
+	for {
+		if exists, err := artifactExistsE(target); err != nil || exists {
+			if exists {
+				touchArtifactManifest(root, target)
+			}
+			return err
+		}
+
+		abandoned, err, _ := artifactGroup.Do(target, func() (interface{}, error) {
+			err := installArtifactE(root, target, install)
+			// Tell the callers waiting on the install whether it failed because this caller
+			// gave up on it, rather than because it cannot succeed.
+			return err != nil && ctx.Err() != nil, err
+		})
+		if abandoned.(bool) && ctx.Err() == nil {
+			continue
+		}
+		return err
+	}
+}
+
+func installArtifactE(root, target string, install func(tmpDir string) (string, error)) error {
+	unlock, err := lockArtifactE(root, target)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have installed the artifact while we waited for the lock.
+	if exists, err := artifactExistsE(target); err != nil || exists {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(root, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src, err := install(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, target); err != nil {
+		return err
+	}
+
+	return writeArtifactManifestE(root, target)
+}
+
+// lockArtifactE takes the cross-process lock for the artifact at target, returning a function
+// to release it.
+func lockArtifactE(root, target string) (func(), error) {
+	rel, err := filepath.Rel(root, target)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("artifact %s is not within the cache at %s", target, root)
+	}
+
+	lockDir := filepath.Join(root, ".locks")
+	if err := os.MkdirAll(lockDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(lockDir, artifactKey(root, target)+".lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("error when attempting to lock %s: %w", f.Name(), err)
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+func artifactExistsE(target string) (bool, error) {
+	_, err := os.Stat(target)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("unexpected error: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,55 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsureArtifactFirstCallerCancels(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "artifact")
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	firstErr := make(chan error, 1)
+	go func() {
+		firstErr <- ensureArtifactE(firstCtx, root, target, func(tmpDir string) (string, error) {
+			close(started)
+			<-firstCtx.Done()
+			return "", firstCtx.Err()
+		})
+	}()
+	<-started
+
+	secondErr := make(chan error, 1)
+	go func() {
+		secondErr <- ensureArtifactE(context.Background(), root, target, func(tmpDir string) (string, error) {
+			src := filepath.Join(tmpDir, "artifact")
+			return src, os.WriteFile(src, []byte("artifact"), 0o644)
+		})
+	}()
+
+	// Give the second caller time to start waiting on the first caller's install.
+	time.Sleep(50 * time.Millisecond)
+	cancelFirst()
+
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the first caller to be cancelled, got %v", err)
+	}
+	if err := <-secondErr; err != nil {
+		t.Fatalf("expected the second caller to install the artifact, got %v", err)
+	}
+
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "artifact" {
+		t.Errorf("expected the artifact to be installed, got %q", content)
+	}
+}
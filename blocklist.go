@@ -0,0 +1,275 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// BlockedVersion is an entry in a Blocklist, naming versions of a release that are known to be
+// broken and shouldn't be tested.
+type BlockedVersion struct {
+	// Version is the blocked version, or a constraint matching several versions such as
+	// ">= 5.0.0, < 5.0.2".
+	Version string
+	// Reason explains why the versions are blocked, and is reported when they are skipped.
+	Reason string
+	// Link optionally points at the issue describing the problem.
+	Link string
+	// Expires is when the entry stops applying, such as when a fix is expected to have been
+	// released. The entry never expires when it is the zero value.
+	Expires time.Time
+}
+
+// blockedVersionJSON is how a BlockedVersion is written in a blocklist file, with an expiry
+// date in either the 2006-01-02 or RFC 3339 format.
+type blockedVersionJSON struct {
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+	Link    string `json:"link,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// UnmarshalJSON reads an entry from a blocklist file, or returns an error if its version
+// constraint or expiry date are invalid or it has no reason.
+func (b *BlockedVersion) UnmarshalJSON(data []byte) error {
+	var raw blockedVersionJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if _, err := version.NewConstraint(raw.Version); err != nil {
+		return fmt.Errorf("invalid blocked version %q: %w", raw.Version, err)
+	}
+
+	if strings.TrimSpace(raw.Reason) == "" {
+		return fmt.Errorf("blocked version %q has no reason", raw.Version)
+	}
+
+	var expires time.Time
+	if raw.Expires != "" {
+		var err error
+		if expires, err = time.Parse("2006-01-02", raw.Expires); err != nil {
+			if expires, err = time.Parse(time.RFC3339, raw.Expires); err != nil {
+				return fmt.Errorf("invalid expiry date %q for blocked version %q", raw.Expires, raw.Version)
+			}
+		}
+	}
+
+	*b = BlockedVersion{Version: raw.Version, Reason: raw.Reason, Link: raw.Link, Expires: expires}
+	return nil
+}
+
+// MarshalJSON writes the entry in the format read by LoadBlocklistE.
+func (b BlockedVersion) MarshalJSON() ([]byte, error) {
+	raw := blockedVersionJSON{Version: b.Version, Reason: b.Reason, Link: b.Link}
+	if !b.Expires.IsZero() {
+		raw.Expires = b.Expires.Format(time.RFC3339)
+	}
+	return json.Marshal(raw)
+}
+
+// blocks reports whether the entry applies to the version at the given time.
+func (b BlockedVersion) blocks(ver *version.Version, now time.Time) bool {
+	if !b.Expires.IsZero() && !now.Before(b.Expires) {
+		return false
+	}
+
+	constraint, err := version.NewConstraint(b.Version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(ver)
+}
+
+// describe returns why the release version is blocked, for the skipped subtest.
+func (b BlockedVersion) describe(release, ver string) string {
+	msg := fmt.Sprintf("%s %s is blocked: %s", release, ver, b.Reason)
+	if b.Link != "" {
+		msg += " (" + b.Link + ")"
+	}
+	if !b.Expires.IsZero() {
+		msg += fmt.Sprintf(" until %s", b.Expires.Format("2006-01-02"))
+	}
+	return msg
+}
+
+// Blocklist holds the versions the matrix tests skip, keyed by release. Engines are keyed by
+// their release name, such as "terraform" or "tofu", and providers by their source address.
+// A provider address without a hostname, such as "hashicorp/aws", blocks the provider in every
+// registry.
+type Blocklist map[string][]BlockedVersion
+
+// DefaultBlocklist returns the versions blocked unless SetBlocklist has been called, which is
+// none. Block the versions known to break a module with SetBlocklist, such as with a Blocklist
+// read by LoadBlocklistE, giving the reason and a link to the issue for each.
+func DefaultBlocklist() Blocklist {
+	return Blocklist{}
+}
+
+var (
+	blocklistMx sync.RWMutex
+	blocklist   = DefaultBlocklist()
+)
+
+// SetBlocklist changes the versions the matrix tests skip. Combine it with DefaultBlocklist
+// using Merge to keep the versions blocked by default.
+func SetBlocklist(b Blocklist) {
+	blocklistMx.Lock()
+	defer blocklistMx.Unlock()
+
+	blocklist = b
+}
+
+// GetBlocklist returns the versions the matrix tests skip.
+func GetBlocklist() Blocklist {
+	blocklistMx.RLock()
+	defer blocklistMx.RUnlock()
+
+	return blocklist
+}
+
+// LoadBlocklistE reads a JSON blocklist from the given file, or returns an error if the file
+// cannot be read or any of its entries are invalid.
+//
+// The file is an object keyed by release, listing the blocked versions of each:
+//
+//	{
+//	  "terraform": [{"version": "1.10.0", "reason": "...", "link": "https://...", "expires": "2025-06-30"}],
+//	  "hashicorp/aws": [{"version": ">= 5.0.0, < 5.0.2", "reason": "..."}]
+//	}
+func LoadBlocklistE(filename string) (Blocklist, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Blocklist
+	if err := json.Unmarshal(content, &b); err != nil {
+		return nil, fmt.Errorf("error when parsing blocklist %s: %w", filename, err)
+	}
+
+	return b, nil
+}
+
+// LoadBlocklist reads a JSON blocklist from the given file, or fails the test if the file
+// cannot be read or any of its entries are invalid.
+func LoadBlocklist(t *testing.T, filename string) Blocklist {
+	b, err := LoadBlocklistE(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Merge returns a Blocklist holding the entries of both blocklists.
+func (b Blocklist) Merge(other Blocklist) Blocklist {
+	merged := Blocklist{}
+	for _, list := range []Blocklist{b, other} {
+		for release, entries := range list {
+			merged[release] = append(merged[release], entries...)
+		}
+	}
+	return merged
+}
+
+// Find returns the unexpired entry blocking the version of the release, if there is one. The
+// release is an engine's release name or a provider's source address.
+func (b Blocklist) Find(release, ver string) (BlockedVersion, bool) {
+	v, err := version.NewVersion(ver)
+	if err != nil {
+		return BlockedVersion{}, false
+	}
+
+	now := time.Now()
+	for _, key := range b.keys(release) {
+		for _, entry := range b[key] {
+			if entry.blocks(v, now) {
+				return entry, true
+			}
+		}
+	}
+
+	return BlockedVersion{}, false
+}
+
+// keys returns the keys the release's entries may be listed under.
+func (b Blocklist) keys(release string) []string {
+	return matchingReleaseKeys(b, release)
+}
+
+// matchingReleaseKeys returns the keys of m that apply to the release: the release itself and,
+// for a provider, its source address in the same registry or without a hostname.
+func matchingReleaseKeys[V any](m map[string]V, release string) []string {
+	keys := []string{release}
+	if !strings.Contains(release, "/") {
+		return keys
+	}
+
+	hostname, namespace, providerType, err := ParseProviderSourceE(release)
+	if err != nil {
+		return keys
+	}
+
+	for key := range m {
+		if key == release || !strings.Contains(key, "/") {
+			continue
+		}
+
+		h, ns, pt, err := ParseProviderSourceE(key)
+		if err != nil || ns != namespace || pt != providerType {
+			continue
+		}
+		if h == hostname || strings.Count(key, "/") == 1 {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// filterBlockedVersions splits the versions of the release into those that may be tested and
+// those the Blocklist blocks, along with the entry blocking each.
+func filterBlockedVersions(release string, versions []string) ([]string, map[string]BlockedVersion) {
+	b := GetBlocklist()
+
+	allowed := make([]string, 0, len(versions))
+	blocked := map[string]BlockedVersion{}
+	for _, ver := range versions {
+		ver := normaliseVersion(ver)
+		if entry, ok := b.Find(release, ver); ok {
+			blocked[ver] = entry
+			continue
+		}
+		allowed = append(allowed, ver)
+	}
+
+	return allowed, blocked
+}
+
+// skipBlockedVersions reports each blocked version of the release as a skipped subtest, named
+// after the version with the given prefix.
+func skipBlockedVersions(t *testing.T, prefix, release string, blocked map[string]BlockedVersion) {
+	versions := make([]string, 0, len(blocked))
+	for ver := range blocked {
+		versions = append(versions, ver)
+	}
+
+	sorted, err := sortVersionStrings(versions)
+	if err != nil {
+		sorted = versions
+	}
+
+	for _, ver := range sorted {
+		msg := blocked[ver].describe(release, ver)
+		t.Run(prefix+ver, func(t *testing.T) {
+			t.Skip(msg)
+		})
+	}
+}
@@ -0,0 +1,644 @@
+package testhelpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// bundleManifestName is the name of the manifest, which is always the first file in a bundle.
+const bundleManifestName = "manifest.json"
+
+// BundleManifest lists every archive in an airgap bundle.
+type BundleManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Terraform lists the archives of Terraform and of every other engine in the bundle.
+	Terraform []BundleArtifact `json:"terraform"`
+	Providers []BundleArtifact `json:"providers"`
+	// Listings are the versions of each engine and provider in the bundle, as listed when the
+	// bundle was exported.
+	Listings []BundleListing `json:"listings,omitempty"`
+}
+
+// BundleArtifact is a single release archive in an airgap bundle.
+type BundleArtifact struct {
+	// Source is the fully qualified source address of a provider, or the release name of an
+	// engine other than Terraform, such as tofu. It is empty for Terraform.
+	Source   string `json:"source,omitempty"`
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	// Path is where the archive is stored in the bundle.
+	Path string `json:"path"`
+	// SHASum is the hex encoded SHA256 checksum of the archive.
+	SHASum string `json:"shasum"`
+}
+
+// BundleListing is the versions of a release as listed from its source when a bundle was
+// exported.
+type BundleListing struct {
+	// Source is where the versions were listed from, such as the base URL of a release source or
+	// provider registry.
+	Source   string            `json:"source"`
+	Release  string            `json:"release"`
+	ListedAt time.Time         `json:"listed_at"`
+	Releases []ReleaseMetadata `json:"releases"`
+}
+
+// BundleOptions controls what is exported into an airgap bundle.
+type BundleOptions struct {
+	// Platforms are the operating systems and architectures to bundle archives for. Only the
+	// current platform is bundled when empty.
+	Platforms []Platform
+
+	// Engines are the engines to bundle, each with the providers it installs. Only Terraform is
+	// bundled when empty.
+	Engines []Engine
+
+	// VersionSelector picks which of the matching versions of Terraform and each provider to
+	// bundle, and should be the same selector the tests use. Every matching version is bundled
+	// when it is nil.
+	VersionSelector VersionSelector
+}
+
+// ExportBundleE resolves the module's required_version and the constraints of every provider in
+// its required_providers blocks, downloads every matching engine and provider release archive,
+// verifying each against its published checksum, and writes them to a single tar archive together
+// with a manifest of the archives and of the versions listed for each release. Import the bundle
+// with ImportBundleE to seed the caches on a runner with no network access.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+// * filename is the tar archive to write.
+// * opts controls the platforms and versions bundled. Use nil for the defaults.
+func ExportBundleE(srcDir, filename string, opts *BundleOptions) (*BundleManifest, error) {
+	return ExportBundleContextE(context.Background(), srcDir, filename, opts)
+}
+
+// ExportBundleContextE writes an airgap bundle of every engine and provider release archive the
+// module can be tested with, stopping when ctx is done.
+//
+// Usage:
+// * ctx is the context of the downloads.
+// * srcDir is the directory that contains the Terraform source files.
+// * filename is the tar archive to write.
+// * opts controls the platforms and versions bundled. Use nil for the defaults.
+func ExportBundleContextE(ctx context.Context, srcDir, filename string, opts *BundleOptions) (*BundleManifest, error) {
+	if opts == nil {
+		opts = &BundleOptions{}
+	}
+
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = []Platform{CurrentPlatform()}
+	}
+
+	stagingDir, err := os.MkdirTemp("", "terraform-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	manifest := &BundleManifest{CreatedAt: time.Now().UTC()}
+	files := map[string]string{}
+
+	engines := opts.Engines
+	if len(engines) == 0 {
+		engines = []Engine{TerraformEngine()}
+	}
+
+	providers, err := GetRequiredProviderNamesE(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	bundled := map[string]bool{}
+	for _, engine := range engines {
+		matching, err := getEngineMatrixVersionsE(ctx, srcDir, engine)
+		if err != nil {
+			return nil, fmt.Errorf("error when resolving the %s versions: %w", engine.Name, err)
+		}
+		engineVersions, err := opts.selectVersionsE(matching)
+		if err != nil {
+			return nil, err
+		}
+		if err := manifest.addListingE(ctx, engine.GetReleaseSource(), engine.Release); err != nil {
+			return nil, err
+		}
+		if len(engineVersions) > 0 {
+			if err := requireSigningKeysE(engine.Release); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, ver := range engineVersions {
+			for _, platform := range platforms {
+				build, err := engine.GetReleaseSource().GetBuild(ctx, engine.Release, ver, platform.OS, platform.Arch)
+				if err != nil {
+					return nil, err
+				}
+
+				artifact, zipPath, err := downloadBundleArtifactE(ctx, engine.Release, build, stagingDir, path.Join(engine.Release, ver, build.Filename))
+				if err != nil {
+					return nil, err
+				}
+
+				if engine.Release != "terraform" {
+					artifact.Source = engine.Release
+				}
+				artifact.Version, artifact.Platform = ver, platform.String()
+				manifest.Terraform = append(manifest.Terraform, artifact)
+				files[artifact.Path] = zipPath
+			}
+		}
+
+		for _, provider := range providers {
+			matching, err := getProviderMatrixVersionsE(ctx, srcDir, provider, engine)
+			if err != nil {
+				return nil, fmt.Errorf("error when resolving the versions of provider %s: %w", provider, err)
+			}
+
+			hostname, namespace, providerType, err := ParseProviderSourceE(matching.release)
+			if err != nil {
+				return nil, err
+			}
+			source := strings.Join([]string{hostname, namespace, providerType}, "/")
+			if bundled[source] {
+				// Engines installing the provider from the same registry share its archives.
+				continue
+			}
+			bundled[source] = true
+
+			versions, err := opts.selectVersionsE(matching)
+			if err != nil {
+				return nil, err
+			}
+			if err := manifest.addListingE(ctx, GetProviderRegistry(hostname), namespace+"/"+providerType); err != nil {
+				return nil, err
+			}
+
+			for _, ver := range versions {
+				for _, platform := range platforms {
+					build, err := GetProviderBuildContextE(ctx, source, ver, platform.OS, platform.Arch)
+					if err != nil {
+						return nil, err
+					}
+
+					artifact, zipPath, err := downloadBundleArtifactE(ctx, source, build, stagingDir, path.Join("providers", source, ver, build.Filename))
+					if err != nil {
+						return nil, err
+					}
+
+					artifact.Source, artifact.Version, artifact.Platform = source, ver, platform.String()
+					manifest.Providers = append(manifest.Providers, artifact)
+					files[artifact.Path] = zipPath
+				}
+			}
+		}
+	}
+
+	if err := writeBundleE(filename, manifest, files); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// ExportBundle writes an airgap bundle of every engine and provider release archive the module
+// can be tested with, or fails the test if something goes wrong.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+// * filename is the tar archive to write.
+// * opts controls the platforms and versions bundled. Use nil for the defaults.
+func ExportBundle(t *testing.T, srcDir, filename string, opts *BundleOptions) *BundleManifest {
+	manifest, err := ExportBundleContextE(testContext(t), srcDir, filename, opts)
+	if err != nil {
+		t.Fatalf("error when attempting to export the bundle: %s", err)
+	}
+	return manifest
+}
+
+// ImportBundleE unpacks every archive in an airgap bundle written by ExportBundleE into the
+// Terraform and provider caches, verifying each against the checksum in the bundle's manifest,
+// and restores the version listings in the manifest into the Cache's VersionsDir, so that the
+// versions matching the module's constraints can be resolved without listing them again.
+// Archives already in the caches, and listings older than those already cached, are skipped. It
+// returns an error if the manifest lists an invalid version, platform or provider source, or an
+// archive the bundle doesn't contain.
+//
+// Restored listings are used until they are older than the version cache TTL, see
+// SetVersionCacheTTL. Turn on offline mode with SetOfflineMode on runners that cannot reach the
+// release sources, so that they are used however old they are.
+//
+// Usage:
+// * filename is the tar archive to import.
+func ImportBundleE(filename string) (*BundleManifest, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("error when reading bundle %s: %w", filename, err)
+	}
+	if header.Name != bundleManifestName {
+		return nil, fmt.Errorf("%s is not a bundle: it does not start with %s", filename, bundleManifestName)
+	}
+
+	manifest := &BundleManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("error when parsing the manifest of bundle %s: %w", filename, err)
+	}
+
+	terraform, providers := map[string]BundleArtifact{}, map[string]BundleArtifact{}
+	for _, listing := range manifest.Listings {
+		if err := validateBundleListingE(listing); err != nil {
+			return nil, fmt.Errorf("invalid manifest in bundle %s: %w", filename, err)
+		}
+	}
+	for _, artifact := range manifest.Terraform {
+		if err := validateBundleArtifactE(artifact, false); err != nil {
+			return nil, fmt.Errorf("invalid manifest in bundle %s: %w", filename, err)
+		}
+		terraform[artifact.Path] = artifact
+	}
+	for _, artifact := range manifest.Providers {
+		if err := validateBundleArtifactE(artifact, true); err != nil {
+			return nil, fmt.Errorf("invalid manifest in bundle %s: %w", filename, err)
+		}
+		providers[artifact.Path] = artifact
+	}
+
+	imported := map[string]bool{}
+
+	cache := GetCache()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when reading bundle %s: %w", filename, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if artifact, ok := terraform[header.Name]; ok {
+			err = importEngineArtifactE(cache, artifact, tr)
+		} else if artifact, ok := providers[header.Name]; ok {
+			err = importProviderArtifactE(cache, artifact, tr)
+		} else {
+			err = fmt.Errorf("%s is not listed in the manifest", header.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when importing %s from bundle %s: %w", header.Name, filename, err)
+		}
+		imported[header.Name] = true
+	}
+
+	var missing []string
+	for _, artifact := range append(append([]BundleArtifact{}, manifest.Terraform...), manifest.Providers...) {
+		if !imported[artifact.Path] {
+			missing = append(missing, artifact.Path)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("bundle %s is missing archives listed in its manifest: %s", filename, strings.Join(missing, ", "))
+	}
+
+	for _, listing := range manifest.Listings {
+		if err := restoreBundleListingE(cache, listing); err != nil {
+			return nil, fmt.Errorf("error when restoring the %s versions from bundle %s: %w", listing.Release, filename, err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// ImportBundle unpacks every archive in an airgap bundle into the Terraform and provider caches
+// and restores its version listings, or fails the test if something goes wrong.
+//
+// Usage:
+// * filename is the tar archive to import.
+func ImportBundle(t *testing.T, filename string) *BundleManifest {
+	manifest, err := ImportBundleE(filename)
+	if err != nil {
+		t.Fatalf("error when attempting to import the bundle: %s", err)
+	}
+	return manifest
+}
+
+// selectVersionsE applies the bundle's VersionSelector, if any, to the matching versions.
+func (opts *BundleOptions) selectVersionsE(matching *matrixVersions) ([]string, error) {
+	return selectVersionsE(opts.VersionSelector, matching.versions, matching.releases)
+}
+
+// addListingE records the releases listed from the source in the manifest. Listings from sources
+// that don't list over the network, which are available without one, aren't recorded.
+func (m *BundleManifest) addListingE(ctx context.Context, src ReleaseSource, release string) error {
+	cacheable, ok := src.(cacheableReleaseSource)
+	if !ok {
+		return nil
+	}
+
+	releases, err := listCachedReleasesE(ctx, src, release)
+	if err != nil {
+		return err
+	}
+
+	m.Listings = append(m.Listings, BundleListing{
+		Source:   cacheable.versionCacheKey(),
+		Release:  release,
+		ListedAt: m.CreatedAt,
+		Releases: releases,
+	})
+	return nil
+}
+
+// downloadBundleArtifactE downloads the release build's archive into dir, returning its manifest
+// entry at the given path in the bundle and where it was downloaded to.
+func downloadBundleArtifactE(ctx context.Context, release string, build *Build, dir, bundlePath string) (BundleArtifact, string, error) {
+	zipPath, err := downloadBuildE(ctx, release, build, dir)
+	if err != nil {
+		return BundleArtifact{}, "", err
+	}
+
+	sum, err := hashFileE(zipPath)
+	if err != nil {
+		return BundleArtifact{}, "", err
+	}
+
+	return BundleArtifact{Path: bundlePath, SHASum: sum}, zipPath, nil
+}
+
+// writeBundleE writes the manifest followed by each of the files, keyed by their path in the
+// bundle, to a new tar archive.
+func writeBundleE(filename string, manifest *BundleManifest, files map[string]string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(filename)
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFileE(tw, bundleManifestName, manifest.CreatedAt, bytes.NewReader(content), int64(len(content))); err != nil {
+		return err
+	}
+
+	for _, artifact := range append(append([]BundleArtifact{}, manifest.Terraform...), manifest.Providers...) {
+		if err := addTarFileE(tw, artifact.Path, manifest.CreatedAt, files[artifact.Path]); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// validateBundleArtifactE returns an error if the manifest entry of an archive is invalid, or if
+// any of the components of the cache path it is imported to could escape the cache.
+func validateBundleArtifactE(artifact BundleArtifact, provider bool) error {
+	if _, err := version.NewVersion(artifact.Version); err != nil {
+		return fmt.Errorf("invalid version %q for %s: %w", artifact.Version, artifact.Path, err)
+	}
+
+	platform, err := ParsePlatformE(artifact.Platform)
+	if err != nil {
+		return err
+	}
+
+	components := []string{artifact.Version, platform.OS, platform.Arch}
+	if !provider && artifact.Source != "" {
+		components = append(components, artifact.Source)
+	}
+	if provider {
+		hostname, namespace, providerType, err := ParseProviderSourceE(artifact.Source)
+		if err != nil {
+			return err
+		}
+		components = append(components, hostname, namespace, providerType)
+	}
+	for _, component := range components {
+		if !isPathComponent(component) {
+			return fmt.Errorf("invalid manifest entry for %s: %q cannot be part of a cache path", artifact.Path, component)
+		}
+	}
+
+	if path.IsAbs(artifact.Path) || path.Clean(artifact.Path) != artifact.Path || strings.HasPrefix(artifact.Path, "../") || !isPathComponent(path.Base(artifact.Path)) {
+		return fmt.Errorf("invalid archive path %q in the manifest", artifact.Path)
+	}
+
+	return nil
+}
+
+// validateBundleListingE returns an error if a version listing in the manifest is incomplete or
+// lists an invalid version.
+func validateBundleListingE(listing BundleListing) error {
+	if listing.Source == "" || listing.Release == "" {
+		return fmt.Errorf("version listing %q from %q must name both its source and release", listing.Release, listing.Source)
+	}
+
+	for _, release := range listing.Releases {
+		if _, err := version.NewVersion(release.Version); err != nil {
+			return fmt.Errorf("invalid version %q in the listing of %s: %w", release.Version, listing.Release, err)
+		}
+	}
+
+	return nil
+}
+
+// isPathComponent reports whether s can be used as a single element of a file path.
+func isPathComponent(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
+
+// addTarFileE copies the file at src into the tar archive with the given name.
+func addTarFileE(tw *tar.Writer, name string, modTime time.Time, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeTarFileE(tw, name, modTime, f, info.Size())
+}
+
+func writeTarFileE(tw *tar.Writer, name string, modTime time.Time, r io.Reader, size int64) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// importEngineArtifactE installs the engine archive read from r into the Terraform cache.
+func importEngineArtifactE(cache *Cache, artifact BundleArtifact, r io.Reader) error {
+	platform, err := ParsePlatformE(artifact.Platform)
+	if err != nil {
+		return err
+	}
+
+	release := artifact.Source
+	if release == "" {
+		release = "terraform"
+	}
+
+	if err := os.MkdirAll(cache.TerraformDir, 0o755); err != nil {
+		return err
+	}
+
+	target := engineBinaryPath(cache.TerraformDir, release, artifact.Version, platform)
+	return ensureArtifactE(context.Background(), cache.TerraformDir, target, func(tmpDir string) (string, error) {
+		zipPath, err := copyBundleArtifactE(artifact, r, tmpDir)
+		if err != nil {
+			return "", err
+		}
+
+		return extractEngineBinaryE(zipPath, tmpDir, release, platform)
+	})
+}
+
+// importProviderArtifactE unpacks the provider archive read from r into the provider cache.
+func importProviderArtifactE(cache *Cache, artifact BundleArtifact, r io.Reader) error {
+	platform, err := ParsePlatformE(artifact.Platform)
+	if err != nil {
+		return err
+	}
+
+	hostname, namespace, providerType, err := ParseProviderSourceE(artifact.Source)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cache.ProviderDir, 0o755); err != nil {
+		return err
+	}
+
+	target := filepath.Join(cache.ProviderDir, hostname, namespace, providerType, artifact.Version, platform.String())
+	return ensureArtifactE(context.Background(), cache.ProviderDir, target, func(tmpDir string) (string, error) {
+		zipPath, err := copyBundleArtifactE(artifact, r, tmpDir)
+		if err != nil {
+			return "", err
+		}
+
+		zipExtractPath := filepath.Join(tmpDir, "bin")
+		if err := extractZipE(zipPath, zipExtractPath); err != nil {
+			return "", err
+		}
+
+		return zipExtractPath, nil
+	})
+}
+
+// restoreBundleListingE writes the version listing into the cache's VersionsDir, unless a listing
+// made since is already cached there.
+func restoreBundleListingE(cache *Cache, listing BundleListing) error {
+	if cache.VersionsDir == "" {
+		return nil
+	}
+
+	filename := versionListingPath(cache.VersionsDir, listing.Source, listing.Release)
+	cached, err := readVersionListingE(filename)
+	if err != nil {
+		return err
+	}
+	if cached != nil && !cached.ListedAt.Before(listing.ListedAt) {
+		return nil
+	}
+
+	return writeVersionListingE(filename, &versionListing{
+		Source:   listing.Source,
+		Release:  listing.Release,
+		ListedAt: listing.ListedAt,
+		Versions: releaseVersions(listing.Releases),
+		Releases: listing.Releases,
+	})
+}
+
+// copyBundleArtifactE copies the archive read from r into dir, verifying it against the
+// checksum in the manifest, and returns its path.
+func copyBundleArtifactE(artifact BundleArtifact, r io.Reader, dir string) (string, error) {
+	zipPath := filepath.Join(dir, path.Base(artifact.Path))
+
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, artifact.SHASum) {
+		return "", &ChecksumMismatchError{Filename: artifact.Path, Expected: artifact.SHASum, Actual: actual}
+	}
+
+	return zipPath, nil
+}
+
+// hashFileE returns the hex encoded SHA256 checksum of the file.
+func hashFileE(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
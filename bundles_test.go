@@ -0,0 +1,230 @@
+package testhelpers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestCache points the package at an empty cache until the test finishes.
+func useTestCache(t *testing.T) *Cache {
+	previous := GetCache()
+	t.Cleanup(func() { SetCache(previous) })
+
+	cache := NewCache(t.TempDir())
+	SetCache(cache)
+	return cache
+}
+
+// writeTestBundle writes a bundle with the given manifest followed by the archives, which need
+// not match it.
+func writeTestBundle(t *testing.T, manifest *BundleManifest, archives map[string][]byte) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "bundle.tar")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tar.NewWriter(f)
+	if err := writeTarFileE(tw, bundleManifestName, manifest.CreatedAt, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	for name, archive := range archives {
+		if err := writeTarFileE(tw, name, manifest.CreatedAt, bytes.NewReader(archive), int64(len(archive))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestImportBundle(t *testing.T) {
+	archive := newTestZip(t, "terraform-provider-example_v1.0.0", "binary")
+	sum := sha256.Sum256(archive)
+	artifact := BundleArtifact{
+		Source:   "registry.terraform.io/example/example",
+		Version:  "1.0.0",
+		Platform: "linux_amd64",
+		Path:     "providers/registry.terraform.io/example/example/1.0.0/terraform-provider-example_1.0.0_linux_amd64.zip",
+		SHASum:   hex.EncodeToString(sum[:]),
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*BundleArtifact)
+		truncate bool
+		wantErr  string
+	}{
+		{name: "valid"},
+		{name: "missing archive", truncate: true, wantErr: "missing archives"},
+		{name: "version traversal", modify: func(a *BundleArtifact) { a.Version = "../../../escape" }, wantErr: "invalid version"},
+		{name: "source traversal", modify: func(a *BundleArtifact) { a.Source = "example.com/../escape" }, wantErr: "cannot be part of a cache path"},
+		{name: "platform traversal", modify: func(a *BundleArtifact) { a.Platform = "../../linux_amd64" }, wantErr: "cannot be part of a cache path"},
+		{name: "windows separator", modify: func(a *BundleArtifact) { a.Source = `example.com/example\..\..\escape/example` }, wantErr: "cannot be part of a cache path"},
+		{name: "archive path traversal", modify: func(a *BundleArtifact) { a.Path = "providers/../../escape.zip" }, wantErr: "invalid archive path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := useTestCache(t)
+
+			artifact := artifact
+			if tt.modify != nil {
+				tt.modify(&artifact)
+			}
+
+			archives := map[string][]byte{artifact.Path: archive}
+			if tt.truncate {
+				archives = nil
+			}
+			manifest := &BundleManifest{CreatedAt: time.Now().UTC(), Providers: []BundleArtifact{artifact}}
+			filename := writeTestBundle(t, manifest, archives)
+
+			_, err := ImportBundleE(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			binary := filepath.Join(cache.ProviderDir, "registry.terraform.io", "example", "example", "1.0.0", "linux_amd64", "terraform-provider-example_v1.0.0")
+			if _, err := os.Stat(binary); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestImportBundleRestoresListings(t *testing.T) {
+	useFastRetries(t)
+
+	previousOffline := GetOfflineMode()
+	t.Cleanup(func() { SetOfflineMode(previousOffline) })
+
+	// Every release source points at a server that is no longer listening.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable := server.URL
+	server.Close()
+
+	terraform := Engine{Name: "terraform", Release: "terraform", ReleaseSource: &HashicorpReleaseSource{BaseURL: unreachable}}
+	tofu := Engine{Name: "tofu", Release: "tofu", ReleaseSource: &OpenTofuReleaseSource{APIURL: unreachable}}
+	SetProviderRegistryURL("registry.bundle.test", unreachable)
+
+	archive := newTestZip(t, "tofu", "binary")
+	sum := sha256.Sum256(archive)
+	artifact := BundleArtifact{
+		Source:   "tofu",
+		Version:  "1.6.0",
+		Platform: CurrentPlatform().String(),
+		Path:     "tofu/1.6.0/tofu_1.6.0_" + CurrentPlatform().String() + ".zip",
+		SHASum:   hex.EncodeToString(sum[:]),
+	}
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		offline bool
+	}{
+		{name: "fresh listings"},
+		{name: "stale listings offline", age: 48 * time.Hour, offline: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := useTestCache(t)
+			SetOfflineMode(tt.offline)
+
+			listedAt := time.Now().Add(-tt.age).UTC()
+			manifest := &BundleManifest{
+				CreatedAt: listedAt,
+				Terraform: []BundleArtifact{artifact},
+				Listings: []BundleListing{
+					{Source: unreachable, Release: "terraform", ListedAt: listedAt, Releases: []ReleaseMetadata{{Version: "1.5.7"}, {Version: "1.6.0"}}},
+					{Source: unreachable, Release: "tofu", ListedAt: listedAt, Releases: []ReleaseMetadata{{Version: "1.6.0"}}},
+					{Source: unreachable, Release: "example/example", ListedAt: listedAt, Releases: []ReleaseMetadata{{Version: "1.0.0"}}},
+				},
+			}
+			if _, err := ImportBundleE(writeTestBundle(t, manifest, map[string][]byte{artifact.Path: archive})); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(engineBinaryPath(cache.TerraformDir, "tofu", "1.6.0", CurrentPlatform())); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			for _, tc := range []struct {
+				list func() ([]string, error)
+				want []string
+			}{
+				{list: func() ([]string, error) { return GetAvailableEngineVersionsContextE(ctx, terraform) }, want: []string{"1.5.7", "1.6.0"}},
+				{list: func() ([]string, error) { return GetAvailableEngineVersionsContextE(ctx, tofu) }, want: []string{"1.6.0"}},
+				{list: func() ([]string, error) {
+					return GetAvailableProviderVersionsContextE(ctx, "registry.bundle.test/example/example")
+				}, want: []string{"1.0.0"}},
+			} {
+				versions, err := tc.list()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(versions, tc.want) {
+					t.Errorf("expected the restored versions %v, got %v", tc.want, versions)
+				}
+			}
+		})
+	}
+}
+
+func TestImportBundleKeepsNewerListings(t *testing.T) {
+	cache := useTestCache(t)
+
+	newer := &versionListing{Source: "https://example.com", Release: "terraform", ListedAt: time.Now().UTC(), Versions: []string{"1.6.0"}}
+	filename := versionListingPath(cache.VersionsDir, newer.Source, newer.Release)
+	if err := writeVersionListingE(filename, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &BundleManifest{
+		CreatedAt: time.Now().Add(-time.Hour).UTC(),
+		Listings: []BundleListing{
+			{Source: newer.Source, Release: newer.Release, ListedAt: time.Now().Add(-time.Hour).UTC(), Releases: []ReleaseMetadata{{Version: "1.5.7"}}},
+		},
+	}
+	if _, err := ImportBundleE(writeTestBundle(t, manifest, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := readVersionListingE(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached == nil || !reflect.DeepEqual(cached.Versions, newer.Versions) {
+		t.Errorf("expected the newer listing %v to be kept, got %+v", newer.Versions, cached)
+	}
+}
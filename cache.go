@@ -0,0 +1,594 @@
+package testhelpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// CacheDirEnvVar is the environment variable that sets the root directory of the cache. When
+// it is set, Terraform binaries are cached in its "terraform" directory and providers in its
+// "providers" directory.
+const CacheDirEnvVar = "TERRAFORM_TESTING_CACHE_DIR"
+
+const (
+	// CachedTerraform is the kind of a cached Terraform binary.
+	CachedTerraform = "terraform"
+	// CachedProvider is the kind of a cached provider.
+	CachedProvider = "provider"
+)
+
+// Cache is where downloaded Terraform binaries and providers are kept. The provider directory
+// uses the layout of Terraform's plugin_cache_dir, so it can be handed straight to Terraform.
+type Cache struct {
+	// TerraformDir holds the Terraform binaries, named terraform_<version>, and those of any
+	// other engine, such as tofu_<version>.
+	TerraformDir string
+	// ProviderDir holds the providers, laid out as <hostname>/<namespace>/<type>/<version>/<os>_<arch>.
+	ProviderDir string
+	// VersionsDir holds the cached listings of each release's versions. See SetVersionCacheTTL.
+	VersionsDir string
+}
+
+// CachedArtifact describes a single Terraform binary or provider in the cache.
+type CachedArtifact struct {
+	// Kind is either CachedTerraform or CachedProvider.
+	Kind string
+	// Source is the provider's source address, such as registry.terraform.io/hashicorp/aws.
+	// It is empty for Terraform binaries, and the release name of any other engine's binaries,
+	// such as "tofu".
+	Source  string
+	Version string
+	// Platform is the <os>_<arch> the artifact was built for.
+	Platform string
+	Path     string
+	Size     int64
+
+	InstalledAt time.Time
+	LastUsed    time.Time
+}
+
+// CachePruneFunc chooses which of the cached artifacts to remove.
+type CachePruneFunc func(artifacts []CachedArtifact) []CachedArtifact
+
+// CacheConstraints are the version constraints still in use, for pruning the artifacts no
+// module needs any more.
+type CacheConstraints struct {
+	// Engines are the required_version constraints in use, keyed by the release name of the
+	// engine they apply to, such as "terraform" or "tofu".
+	Engines map[string][]string
+	// Providers are the provider constraints in use, keyed by source address.
+	Providers map[string][]string
+}
+
+var (
+	cacheMx      sync.RWMutex
+	defaultCache *Cache
+)
+
+// NewCache returns a Cache rooted at the given directory.
+func NewCache(root string) *Cache {
+	return &Cache{
+		TerraformDir: filepath.Join(root, "terraform"),
+		ProviderDir:  filepath.Join(root, "providers"),
+		VersionsDir:  filepath.Join(root, "versions"),
+	}
+}
+
+// SetCache changes the Cache used by the package level download functions.
+func SetCache(c *Cache) {
+	cacheMx.Lock()
+	defer cacheMx.Unlock()
+
+	defaultCache = c
+}
+
+// SetCacheRoot changes the Cache used by the package level download functions to one rooted
+// at the given directory.
+func SetCacheRoot(root string) {
+	SetCache(NewCache(root))
+}
+
+// GetCache returns the Cache used by the package level download functions. Unless it has been
+// changed with SetCache or the TERRAFORM_TESTING_CACHE_DIR environment variable, Terraform
+// binaries are kept in ~/.terraform.versions, providers in ~/.terraform.d/plugin-cache and
+// version listings in ~/.terraform.versions/.releases.
+func GetCache() *Cache {
+	cacheMx.RLock()
+	defer cacheMx.RUnlock()
+
+	if defaultCache != nil {
+		return defaultCache
+	}
+
+	if root := os.Getenv(CacheDirEnvVar); root != "" {
+		return NewCache(root)
+	}
+
+	homeDirectory, _ := os.UserHomeDir()
+	return &Cache{
+		TerraformDir: filepath.Join(homeDirectory, ".terraform.versions"),
+		ProviderDir:  filepath.Join(homeDirectory, ".terraform.d/plugin-cache"),
+		VersionsDir:  filepath.Join(homeDirectory, ".terraform.versions", ".releases"),
+	}
+}
+
+// List returns every Terraform binary and provider in the cache.
+func (c *Cache) List() ([]CachedArtifact, error) {
+	artifacts, err := c.listTerraform()
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := c.listProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(artifacts, providers...), nil
+}
+
+// DiskUsage returns the total size in bytes of the artifacts in the cache.
+func (c *Cache) DiskUsage() (int64, error) {
+	artifacts, err := c.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, artifact := range artifacts {
+		total += artifact.Size
+	}
+	return total, nil
+}
+
+// Verify checks every artifact in the cache against the digest recorded when it was installed
+// and returns the ones that have been modified or corrupted. Artifacts installed by earlier
+// versions of this package have no recorded digest and are not checked.
+func (c *Cache) Verify() ([]CachedArtifact, error) {
+	artifacts, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var corrupted []CachedArtifact
+	for _, artifact := range artifacts {
+		manifest, err := readArtifactManifestE(c.root(artifact), artifact.Path)
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil {
+			continue
+		}
+
+		digest, err := hashArtifactE(artifact.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if digest != manifest.Digest {
+			corrupted = append(corrupted, artifact)
+		}
+	}
+
+	return corrupted, nil
+}
+
+// Prune removes the artifacts chosen by each of the given functions, such as PruneOlderThan,
+// PruneLeastRecentlyUsed or PruneUnmatched, and returns the artifacts removed.
+func (c *Cache) Prune(fns ...CachePruneFunc) ([]CachedArtifact, error) {
+	artifacts, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := map[string]CachedArtifact{}
+	for _, fn := range fns {
+		for _, artifact := range fn(artifacts) {
+			chosen[artifact.Path] = artifact
+		}
+	}
+
+	var removed []CachedArtifact
+	for _, artifact := range artifacts {
+		if _, ok := chosen[artifact.Path]; !ok {
+			continue
+		}
+
+		if err := c.remove(artifact); err != nil {
+			return removed, err
+		}
+		removed = append(removed, artifact)
+	}
+
+	return removed, nil
+}
+
+// PruneOlderThan chooses the artifacts installed more than the given duration ago.
+func PruneOlderThan(age time.Duration) CachePruneFunc {
+	return func(artifacts []CachedArtifact) []CachedArtifact {
+		cutoff := time.Now().Add(-age)
+
+		var chosen []CachedArtifact
+		for _, artifact := range artifacts {
+			if artifact.InstalledAt.Before(cutoff) {
+				chosen = append(chosen, artifact)
+			}
+		}
+		return chosen
+	}
+}
+
+// PruneLeastRecentlyUsed chooses the least recently used artifacts until the rest fit within
+// the given number of bytes.
+func PruneLeastRecentlyUsed(maxBytes int64) CachePruneFunc {
+	return func(artifacts []CachedArtifact) []CachedArtifact {
+		sorted := append([]CachedArtifact(nil), artifacts...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].LastUsed.After(sorted[j].LastUsed)
+		})
+
+		var total int64
+		var chosen []CachedArtifact
+		for _, artifact := range sorted {
+			total += artifact.Size
+			if total > maxBytes {
+				chosen = append(chosen, artifact)
+			}
+		}
+		return chosen
+	}
+}
+
+// PruneUnmatched chooses the artifacts whose version doesn't satisfy any of the given
+// constraints. Providers without any constraint are chosen too, while the binaries of engines
+// without any constraint are kept, as nothing is known about the versions in use.
+func PruneUnmatched(constraints CacheConstraints) CachePruneFunc {
+	return func(artifacts []CachedArtifact) []CachedArtifact {
+		providers := map[string][]string{}
+		for source, cs := range constraints.Providers {
+			hostname, namespace, providerType, err := ParseProviderSourceE(source)
+			if err != nil {
+				continue
+			}
+			key := strings.Join([]string{hostname, namespace, providerType}, "/")
+			providers[key] = append(providers[key], cs...)
+		}
+
+		var chosen []CachedArtifact
+		for _, artifact := range artifacts {
+			var cs []string
+			if artifact.Kind == CachedProvider {
+				cs = providers[artifact.Source]
+			} else {
+				release := artifact.Source
+				if release == "" {
+					release = "terraform"
+				}
+
+				cs = constraints.Engines[release]
+				if len(cs) == 0 {
+					continue
+				}
+			}
+
+			if !matchesAnyConstraint(artifact.Version, cs) {
+				chosen = append(chosen, artifact)
+			}
+		}
+		return chosen
+	}
+}
+
+func matchesAnyConstraint(ver string, constraints []string) bool {
+	v, err := version.NewVersion(ver)
+	if err != nil {
+		return false
+	}
+
+	for _, constraint := range constraints {
+		c, err := version.NewConstraint(constraint)
+		if err != nil {
+			continue
+		}
+		if checkConstraint(c, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cache) root(artifact CachedArtifact) string {
+	if artifact.Kind == CachedProvider {
+		return c.ProviderDir
+	}
+	return c.TerraformDir
+}
+
+func (c *Cache) remove(artifact CachedArtifact) error {
+	root := c.root(artifact)
+
+	unlock, err := lockArtifactE(root, artifact.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.RemoveAll(artifact.Path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(artifactManifestPath(root, artifact.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Cache) listTerraform() ([]CachedArtifact, error) {
+	artifacts, err := c.listTerraformDir(c.TerraformDir, CurrentPlatform())
+	if err != nil {
+		return nil, err
+	}
+
+	// Binaries for other platforms are kept in <os>_<arch> directories.
+	entries, err := readDirIfExists(c.TerraformDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		platform, err := ParsePlatformE(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		platformArtifacts, err := c.listTerraformDir(filepath.Join(c.TerraformDir, entry.Name()), platform)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, platformArtifacts...)
+	}
+
+	return artifacts, nil
+}
+
+func (c *Cache) listTerraformDir(dir string, platform Platform) ([]CachedArtifact, error) {
+	entries, err := readDirIfExists(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []CachedArtifact
+	for _, entry := range entries {
+		release, ver, ok := strings.Cut(entry.Name(), "_")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := version.NewVersion(ver); err != nil {
+			continue
+		}
+
+		artifact, err := newCachedArtifactE(c.TerraformDir, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		artifact.Kind = CachedTerraform
+		if release != "terraform" {
+			artifact.Source = release
+		}
+		artifact.Version = ver
+		artifact.Platform = platform.String()
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, nil
+}
+
+func (c *Cache) listProviders() ([]CachedArtifact, error) {
+	var artifacts []CachedArtifact
+
+	// Walk the <hostname>/<namespace>/<type>/<version>/<os>_<arch> layout of the cache.
+	var walk func(dir string, parts []string) error
+	walk = func(dir string, parts []string) error {
+		entries, err := readDirIfExists(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if len(parts) < 4 {
+				if err := walk(path, append(parts[:len(parts):len(parts)], entry.Name())); err != nil {
+					return err
+				}
+				continue
+			}
+
+			artifact, err := newCachedArtifactE(c.ProviderDir, path)
+			if err != nil {
+				return err
+			}
+			artifact.Kind = CachedProvider
+			artifact.Source = strings.Join(parts[:3], "/")
+			artifact.Version = parts[3]
+			artifact.Platform = entry.Name()
+			artifacts = append(artifacts, artifact)
+		}
+
+		return nil
+	}
+
+	if err := walk(c.ProviderDir, nil); err != nil {
+		return nil, err
+	}
+
+	return artifacts, nil
+}
+
+func newCachedArtifactE(root, path string) (CachedArtifact, error) {
+	artifact := CachedArtifact{Path: path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return artifact, err
+	}
+	artifact.InstalledAt = info.ModTime()
+	artifact.LastUsed = info.ModTime()
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		artifact.Size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return artifact, err
+	}
+
+	manifest, err := readArtifactManifestE(root, path)
+	if err != nil {
+		return artifact, err
+	}
+	if manifest != nil {
+		artifact.InstalledAt = manifest.InstalledAt
+	}
+
+	if info, err := os.Stat(artifactManifestPath(root, path)); err == nil {
+		artifact.LastUsed = info.ModTime()
+	}
+
+	return artifact, nil
+}
+
+func readDirIfExists(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// artifactManifest records how an artifact looked when it was installed into the cache.
+type artifactManifest struct {
+	Digest      string    `json:"digest"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// artifactManifestPath returns where the manifest for the artifact at target is kept. The
+// file's modification time records when the artifact was last used.
+func artifactManifestPath(root, target string) string {
+	return filepath.Join(root, ".manifests", artifactKey(root, target)+".json")
+}
+
+// artifactKey returns a flat name for the artifact at target, unique within the cache root.
+func artifactKey(root, target string) string {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		rel = target
+	}
+	return strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+}
+
+func writeArtifactManifestE(root, target string) error {
+	digest, err := hashArtifactE(target)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(artifactManifest{Digest: digest, InstalledAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	path := artifactManifestPath(root, target)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0o644)
+}
+
+// readArtifactManifestE returns the manifest of the artifact at target, or nil if it has none.
+func readArtifactManifestE(root, target string) (*artifactManifest, error) {
+	content, err := os.ReadFile(artifactManifestPath(root, target))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest artifactManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("error when parsing the cache manifest for %s: %w", target, err)
+	}
+	return &manifest, nil
+}
+
+// touchArtifactManifest marks the artifact at target as used now.
+func touchArtifactManifest(root, target string) {
+	now := time.Now()
+	_ = os.Chtimes(artifactManifestPath(root, target), now, now)
+}
+
+// hashArtifactE returns a SHA256 digest of the file, or of every file beneath the directory,
+// at the given path.
+func hashArtifactE(path string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fileHash := sha256.New()
+		if _, err := io.Copy(fileHash, f); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%x  %s\n", fileHash.Sum(nil), filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,57 @@
+package testhelpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPruneUnmatched(t *testing.T) {
+	artifacts := []CachedArtifact{
+		{Kind: CachedTerraform, Version: "1.4.6", Path: "terraform_1.4.6"},
+		{Kind: CachedTerraform, Version: "1.5.7", Path: "terraform_1.5.7"},
+		{Kind: CachedTerraform, Source: "tofu", Version: "1.6.0", Path: "tofu_1.6.0"},
+		{Kind: CachedTerraform, Source: "tofu", Version: "1.7.0", Path: "tofu_1.7.0"},
+		{Kind: CachedProvider, Source: "registry.terraform.io/hashicorp/aws", Version: "4.67.0", Path: "aws/4.67.0"},
+		{Kind: CachedProvider, Source: "registry.terraform.io/hashicorp/aws", Version: "5.31.0", Path: "aws/5.31.0"},
+		{Kind: CachedProvider, Source: "registry.terraform.io/hashicorp/null", Version: "3.2.2", Path: "null/3.2.2"},
+	}
+
+	tests := []struct {
+		name        string
+		constraints CacheConstraints
+		want        []string
+	}{
+		{
+			name: "terraform and providers",
+			constraints: CacheConstraints{
+				Engines:   map[string][]string{"terraform": {">= 1.5.0"}},
+				Providers: map[string][]string{"hashicorp/aws": {"~> 5.0"}},
+			},
+			want: []string{"terraform_1.4.6", "aws/4.67.0", "null/3.2.2"},
+		},
+		{
+			name: "every engine",
+			constraints: CacheConstraints{
+				Engines: map[string][]string{"terraform": {"< 1.5.0", ">= 1.5.7"}, "tofu": {"~> 1.7.0"}},
+			},
+			want: []string{"tofu_1.6.0", "aws/4.67.0", "aws/5.31.0", "null/3.2.2"},
+		},
+		{
+			name:        "no constraints",
+			constraints: CacheConstraints{},
+			want:        []string{"aws/4.67.0", "aws/5.31.0", "null/3.2.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, artifact := range PruneUnmatched(tt.constraints)(artifacts) {
+				got = append(got, artifact.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v to be pruned, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// CLIConfigEnvVar is the environment variable Terraform reads the location of its CLI
+// configuration file from.
+const CLIConfigEnvVar = "TF_CLI_CONFIG_FILE"
+
+// CLIConfig describes a Terraform CLI configuration file.
+type CLIConfig struct {
+	// PluginCacheDir is where Terraform caches providers it installs directly. It must not be
+	// one of the filesystem mirror directories.
+	PluginCacheDir string
+
+	// FilesystemMirrors are local directories Terraform installs providers from.
+	FilesystemMirrors []ProviderInstallationMethod
+	// NetworkMirrors are HTTPS servers implementing the provider network mirror protocol that
+	// Terraform installs providers from.
+	NetworkMirrors []ProviderInstallationMethod
+	// Direct allows providers to be installed from their origin registries. Direct installation
+	// is disabled when it is nil and any mirror is configured.
+	Direct *ProviderInstallationMethod
+}
+
+// ProviderInstallationMethod is a single method in the provider_installation block of a CLI
+// configuration file.
+type ProviderInstallationMethod struct {
+	// Location is the directory of a filesystem mirror or the base URL of a network mirror. It
+	// is ignored for direct installation.
+	Location string
+	// Include are the provider source address patterns, such as "registry.terraform.io/*/*",
+	// this method is used for. Every provider is included when empty.
+	Include []string
+	// Exclude are the provider source address patterns this method is not used for.
+	Exclude []string
+}
+
+// NewHermeticCLIConfig returns a CLIConfig that installs the given providers only from the
+// provider cache, so that init never reaches the network for them, and every other provider
+// directly from its registry.
+//
+// Usage:
+//   - sourceAddresses are the source addresses of the providers to install from the cache.
+func NewHermeticCLIConfig(sourceAddresses []string) CLIConfig {
+	patterns := providerSourcePatterns(sourceAddresses)
+
+	return CLIConfig{
+		FilesystemMirrors: []ProviderInstallationMethod{{Location: GetBinaryPath(), Include: patterns}},
+		Direct:            &ProviderInstallationMethod{Exclude: patterns},
+	}
+}
+
+// providerSourcePatterns returns the fully qualified source addresses of the providers, for the
+// include and exclude patterns of an installation method. Invalid addresses are left out.
+func providerSourcePatterns(sourceAddresses []string) []string {
+	patterns := make([]string, 0, len(sourceAddresses))
+	for _, sourceAddress := range sourceAddresses {
+		hostname, namespace, providerType, err := ParseProviderSourceE(sourceAddress)
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, strings.Join([]string{hostname, namespace, providerType}, "/"))
+	}
+	return patterns
+}
+
+// WriteCLIConfigE writes the CLI configuration to the given file.
+//
+// Usage:
+//   - filename is the file to write the configuration to.
+//   - cfg is the configuration to write.
+func WriteCLIConfigE(filename string, cfg CLIConfig) error {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+
+	if cfg.PluginCacheDir != "" {
+		body.SetAttributeValue("plugin_cache_dir", cty.StringVal(cfg.PluginCacheDir))
+	}
+
+	if len(cfg.FilesystemMirrors) > 0 || len(cfg.NetworkMirrors) > 0 || cfg.Direct != nil {
+		installation := body.AppendNewBlock("provider_installation", nil).Body()
+
+		for _, mirror := range cfg.FilesystemMirrors {
+			method := installation.AppendNewBlock("filesystem_mirror", nil).Body()
+			method.SetAttributeValue("path", cty.StringVal(mirror.Location))
+			setPatternsAttributes(method, mirror)
+		}
+
+		for _, mirror := range cfg.NetworkMirrors {
+			method := installation.AppendNewBlock("network_mirror", nil).Body()
+			method.SetAttributeValue("url", cty.StringVal(mirror.Location))
+			setPatternsAttributes(method, mirror)
+		}
+
+		if cfg.Direct != nil {
+			setPatternsAttributes(installation.AppendNewBlock("direct", nil).Body(), *cfg.Direct)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, hclwrite.Format(f.Bytes()), 0o666)
+}
+
+// WriteCLIConfig writes the CLI configuration to the given file, or fails the test if
+// something goes wrong.
+//
+// Usage:
+//   - filename is the file to write the configuration to.
+//   - cfg is the configuration to write.
+func WriteCLIConfig(t *testing.T, filename string, cfg CLIConfig) {
+	if err := WriteCLIConfigE(filename, cfg); err != nil {
+		t.Fatalf("error when attempting to write the CLI configuration: %s", err)
+	}
+}
+
+func setPatternsAttributes(body *hclwrite.Body, method ProviderInstallationMethod) {
+	if len(method.Include) > 0 {
+		body.SetAttributeValue("include", stringListVal(method.Include))
+	}
+	if len(method.Exclude) > 0 {
+		body.SetAttributeValue("exclude", stringListVal(method.Exclude))
+	}
+}
+
+func stringListVal(values []string) cty.Value {
+	vals := make([]cty.Value, 0, len(values))
+	for _, v := range values {
+		vals = append(vals, cty.StringVal(v))
+	}
+	return cty.ListVal(vals)
+}
@@ -0,0 +1,108 @@
+// Command terraform-bundle exports the Terraform and provider releases a module can be tested
+// with into an airgap bundle, and imports bundles into the caches of a disconnected runner.
+//
+// Usage:
+//
+//	terraform-bundle export [-platforms linux_amd64,darwin_arm64] [-latest-patch] -out bundle.tar <module dir>
+//	terraform-bundle import [-cache-dir dir] bundle.tar
+//
+// The caches are found the same way the tests find them, so set TERRAFORM_TESTING_CACHE_DIR,
+// or -cache-dir, to import into a shared cache.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	testhelpers "github.com/ovotech/terraform-testing"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "import":
+		err = importBundle(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "terraform-bundle: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: terraform-bundle export [-platforms os_arch,...] [-latest-patch] -out bundle.tar <module dir>")
+	fmt.Fprintln(os.Stderr, "       terraform-bundle import [-cache-dir dir] bundle.tar")
+	os.Exit(2)
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "bundle.tar", "the bundle to write")
+	platforms := flags.String("platforms", "", "comma separated <os>_<arch> platforms to bundle, the current platform by default")
+	latestPatch := flags.Bool("latest-patch", false, "only bundle the latest patch release of each minor version")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	opts := &testhelpers.BundleOptions{}
+	if *platforms != "" {
+		for _, platform := range strings.Split(*platforms, ",") {
+			p, err := testhelpers.ParsePlatformE(strings.TrimSpace(platform))
+			if err != nil {
+				return err
+			}
+			opts.Platforms = append(opts.Platforms, p)
+		}
+	}
+	if *latestPatch {
+		opts.VersionSelector = testhelpers.SelectLatestPatchVersions()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	manifest, err := testhelpers.ExportBundleContextE(ctx, flags.Arg(0), *out, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %d Terraform and %d provider archives to %s\n", len(manifest.Terraform), len(manifest.Providers), *out)
+	return nil
+}
+
+func importBundle(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	cacheDir := flags.String("cache-dir", "", "the root of the cache to import into")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	if *cacheDir != "" {
+		testhelpers.SetCacheRoot(*cacheDir)
+	}
+
+	manifest, err := testhelpers.ImportBundleE(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d Terraform and %d provider archives from %s\n", len(manifest.Terraform), len(manifest.Providers), flags.Arg(0))
+	return nil
+}
@@ -0,0 +1,335 @@
+package testhelpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// HTTPError is returned when a request to a release source or download URL doesn't return
+// a successful response.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// Body is the start of the response body, which usually explains the failure.
+	Body string
+	// RetryAfter is how long the server asked for the request not to be retried, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected response from %s: %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("unexpected response from %s: %s: %s", e.URL, e.Status, e.Body)
+}
+
+// ChecksumMismatchError is returned when a downloaded archive doesn't match the checksum
+// published for it.
+type ChecksumMismatchError struct {
+	Filename string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s but got %s", e.Filename, e.Expected, e.Actual)
+}
+
+// SignatureError is returned when the signature of a SHA256SUMS file cannot be verified
+// against any of the trusted signing keys, or when a download that must be signed isn't.
+type SignatureError struct {
+	URL string
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("unable to verify the signature of %s: %s", e.URL, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	trustedSigningKeysMx     sync.RWMutex
+	trustedSigningKeys       []string
+	releaseSigningKeys       = DefaultReleaseSigningKeys()
+	trustRegistrySigningKeys bool
+)
+
+// SetTrustedSigningKeys sets the ASCII armored public keys that SHA256SUMS signatures are
+// verified against for every release, in addition to the keys trusted for each release with
+// SetReleaseSigningKeys. Once any key is trusted for a release, its downloads must have a
+// SHA256SUMS file signed by one of them.
+func SetTrustedSigningKeys(keys ...string) {
+	trustedSigningKeysMx.Lock()
+	defer trustedSigningKeysMx.Unlock()
+
+	trustedSigningKeys = keys
+}
+
+// LoadTrustedSigningKeysE reads an ASCII armored public key file and trusts the keys it
+// contains, or returns an error if the file cannot be read or doesn't contain a valid key.
+func LoadTrustedSigningKeysE(filename string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("error when reading signing keys from %s: %w", filename, err)
+	}
+
+	SetTrustedSigningKeys(string(content))
+	return nil
+}
+
+// DefaultReleaseSigningKeys returns the keys trusted for each release unless
+// SetReleaseSigningKeys has been called, which is HashicorpSigningKey for Terraform. No key is
+// trusted for other engines, such as OpenTofu, which cannot be downloaded until their
+// publisher's key is trusted with SetReleaseSigningKeys, or they are opted out of verification.
+func DefaultReleaseSigningKeys() map[string][]string {
+	return map[string][]string{
+		"terraform": {HashicorpSigningKey},
+	}
+}
+
+// SetReleaseSigningKeys sets the ASCII armored public keys that the SHA256SUMS signatures of a
+// single release are verified against, replacing those trusted for it before. Pass no keys to
+// opt the release out of signature verification, such as when testing against a stand-in for
+// the releases API. A provider address without a hostname, such as "hashicorp/aws", applies to
+// the provider in every registry.
+//
+// Usage:
+// * release is an engine's release name, such as "terraform", or a provider's source address.
+// * keys are the ASCII armored public keys to trust.
+func SetReleaseSigningKeys(release string, keys ...string) {
+	trustedSigningKeysMx.Lock()
+	defer trustedSigningKeysMx.Unlock()
+
+	updated := make(map[string][]string, len(releaseSigningKeys)+1)
+	for r, k := range releaseSigningKeys {
+		updated[r] = k
+	}
+	updated[release] = keys
+	releaseSigningKeys = updated
+}
+
+// SetTrustRegistrySigningKeys changes whether the keys a provider registry publishes alongside
+// a provider are trusted to sign it, as Terraform does. They are not trusted by default, as they
+// come from the same registry as the archive they vouch for, so a compromised registry or mirror
+// could publish its own key.
+func SetTrustRegistrySigningKeys(trust bool) {
+	trustedSigningKeysMx.Lock()
+	defer trustedSigningKeysMx.Unlock()
+
+	trustRegistrySigningKeys = trust
+}
+
+// getTrustedSigningKeys returns the keys trusted to sign the release's build: those trusted for
+// every release, those trusted for the release, and those published with the build when
+// registry keys are trusted.
+func getTrustedSigningKeys(release string, build *Build) []string {
+	trustedSigningKeysMx.RLock()
+	defer trustedSigningKeysMx.RUnlock()
+
+	keys := append([]string{}, trustedSigningKeys...)
+	for _, key := range matchingReleaseKeys(releaseSigningKeys, release) {
+		keys = append(keys, releaseSigningKeys[key]...)
+	}
+	if trustRegistrySigningKeys {
+		keys = append(keys, build.SigningKeys...)
+	}
+	return keys
+}
+
+// requireSigningKeysE returns an error unless keys are trusted to sign the release, or it has
+// been opted out of signature verification with SetReleaseSigningKeys. Engines must be one or
+// the other before they are downloaded, as nothing else vouches for the binaries tests run.
+func requireSigningKeysE(release string) error {
+	trustedSigningKeysMx.RLock()
+	defer trustedSigningKeysMx.RUnlock()
+
+	if len(trustedSigningKeys) > 0 {
+		return nil
+	}
+	for _, key := range matchingReleaseKeys(releaseSigningKeys, release) {
+		if _, ok := releaseSigningKeys[key]; ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no signing key is trusted for %s: trust its publisher's key with SetReleaseSigningKeys, or call SetReleaseSigningKeys(%q) to download it without verifying its signature", release, release)
+}
+
+// getExpectedChecksumE returns the checksum the release's build must match. When the build
+// has a SHA256SUMS file its signature is verified, and the checksum it lists is used. The file
+// is only fetched if the release source didn't already fetch it to resolve the build.
+func getExpectedChecksumE(ctx context.Context, release string, build *Build) (string, error) {
+	if build.SHASumsURL == "" {
+		if len(getTrustedSigningKeys(release, build)) > 0 {
+			return "", &SignatureError{URL: build.URL, Err: errors.New("no signed SHA256SUMS file is published for the build")}
+		}
+		if build.SHASum == "" {
+			return "", fmt.Errorf("no checksum is available to verify %s", build.Filename)
+		}
+		return build.SHASum, nil
+	}
+
+	content := build.shaSums
+	if content == nil {
+		var err error
+		if content, err = getBody(ctx, build.SHASumsURL); err != nil {
+			return "", err
+		}
+	}
+
+	if err := verifySignatureE(ctx, release, build, content); err != nil {
+		return "", err
+	}
+
+	expected, ok := parseSHA256Sums(content)[build.Filename]
+	if !ok {
+		return "", fmt.Errorf("%s is not listed in %s", build.Filename, build.SHASumsURL)
+	}
+
+	if build.SHASum != "" && !strings.EqualFold(build.SHASum, expected) {
+		return "", &ChecksumMismatchError{Filename: build.Filename, Expected: expected, Actual: build.SHASum}
+	}
+
+	return expected, nil
+}
+
+// verifySignatureE checks the detached signature of the SHA256SUMS file of the release's build
+// against the keys trusted to sign it. Nothing is checked when no keys are trusted for the
+// release, but once any are, a build without a signature fails verification.
+//
+// A signature made by a trusted key that has since expired is accepted, as the SHA256SUMS files
+// of older releases stay signed by the key they were released with. Revoked keys are not.
+func verifySignatureE(ctx context.Context, release string, build *Build, shasums []byte) error {
+	keys := getTrustedSigningKeys(release, build)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if build.SHASumsSignatureURL == "" {
+		return &SignatureError{URL: build.SHASumsURL, Err: errors.New("no signature is published for the SHA256SUMS file")}
+	}
+
+	var keyring openpgp.EntityList
+	for _, key := range keys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+		if err != nil {
+			return &SignatureError{URL: build.SHASumsSignatureURL, Err: err}
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	signature, err := getBody(ctx, build.SHASumsSignatureURL)
+	if err != nil {
+		return err
+	}
+	if len(signature) == 0 {
+		return &SignatureError{URL: build.SHASumsSignatureURL, Err: errors.New("the signature is empty")}
+	}
+
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(shasums), bytes.NewReader(signature), nil)
+	if err != nil && !errors.Is(err, pgperrors.ErrKeyExpired) {
+		return &SignatureError{URL: build.SHASumsSignatureURL, Err: err}
+	}
+
+	return nil
+}
+
+// downloadBuildE downloads the release build's archive into a new file in the given directory
+// and verifies it against its published checksum, returning the path of the archive. The
+// archive is removed again if it cannot be verified.
+func downloadBuildE(ctx context.Context, release string, build *Build, dir string) (zipPath string, err error) {
+	expected, err := getExpectedChecksumE(ctx, release, build)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp(dir, build.Filename+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(out.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	err = withRetriesE(ctx, func() error {
+		// Start again from scratch if an earlier attempt failed part way through.
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		hash.Reset()
+
+		resp, err := doGetE(ctx, build.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		_, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return "", &ChecksumMismatchError{Filename: build.Filename, Expected: expected, Actual: actual}
+	}
+
+	return out.Name(), nil
+}
+
+// extractZipE extracts every file in the archive into the dst directory.
+func extractZipE(zipPath, dst string) (err error) {
+	// Sample code to extract zip file taken from https://stackoverflow.com/questions/20357223/easy-way-to-unzip-file-with-golang
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+
+	for _, f := range r.File {
+		if err := extractAndWriteFile(dst, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
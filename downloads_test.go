@@ -0,0 +1,326 @@
+package testhelpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// testSigningKey is a generated key pair for signing SHA256SUMS files in tests.
+type testSigningKey struct {
+	entity *openpgp.Entity
+	// Armored is the ASCII armored public key.
+	Armored string
+}
+
+func newTestSigningKey(t *testing.T) *testSigningKey {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSigningKey{entity: entity, Armored: buf.String()}
+}
+
+// Sign returns the detached signature of content.
+func (k *testSigningKey) Sign(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var sig bytes.Buffer
+	if err := openpgp.DetachSign(&sig, k.entity, bytes.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	return sig.Bytes()
+}
+
+// restoreSigningKeys restores the trusted signing keys when the test finishes.
+func restoreSigningKeys(t *testing.T) {
+	trustedSigningKeysMx.RLock()
+	keys, release, registry := trustedSigningKeys, releaseSigningKeys, trustRegistrySigningKeys
+	trustedSigningKeysMx.RUnlock()
+
+	t.Cleanup(func() {
+		trustedSigningKeysMx.Lock()
+		defer trustedSigningKeysMx.Unlock()
+
+		trustedSigningKeys, releaseSigningKeys, trustRegistrySigningKeys = keys, release, registry
+	})
+}
+
+// newTestZip returns a zip archive holding a single file.
+func newTestZip(t *testing.T, name, content string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serveSignedBuild serves the archive with a SHA256SUMS file signed by the key, returning the
+// build describing them.
+func serveSignedBuild(t *testing.T, archive []byte, key *testSigningKey) *Build {
+	t.Helper()
+
+	sum := sha256.Sum256(archive)
+	sums := []byte(fmt.Sprintf("%s  example.zip\n", hex.EncodeToString(sum[:])))
+	sig := key.Sign(t, sums)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/example.zip", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(archive) })
+	mux.HandleFunc("/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(sums) })
+	mux.HandleFunc("/SHA256SUMS.sig", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(sig) })
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &Build{
+		Filename:            "example.zip",
+		URL:                 server.URL + "/example.zip",
+		SHASumsURL:          server.URL + "/SHA256SUMS",
+		SHASumsSignatureURL: server.URL + "/SHA256SUMS.sig",
+	}
+}
+
+func TestHashicorpSigningKey(t *testing.T) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(HashicorpSigningKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint); got != "C874011F0AB405110D02105534365D9472D7468F" {
+		t.Errorf("unexpected fingerprint %s", got)
+	}
+}
+
+func TestDownloadBuildVerifiesSignature(t *testing.T) {
+	restoreSigningKeys(t)
+
+	trusted := newTestSigningKey(t)
+	untrusted := newTestSigningKey(t)
+	archive := newTestZip(t, "terraform", "binary")
+
+	tests := []struct {
+		name     string
+		key      *testSigningKey
+		registry bool
+		modify   func(*Build)
+		wantErr  bool
+	}{
+		{name: "trusted key", key: trusted},
+		{name: "untrusted key", key: untrusted, wantErr: true},
+		{
+			name:    "untrusted key published with the build",
+			key:     untrusted,
+			modify:  func(b *Build) { b.SigningKeys = []string{untrusted.Armored} },
+			wantErr: true,
+		},
+		{
+			name:     "trusted registry key published with the build",
+			key:      untrusted,
+			registry: true,
+			modify:   func(b *Build) { b.SigningKeys = []string{untrusted.Armored} },
+		},
+		{
+			name:    "missing signature",
+			key:     trusted,
+			modify:  func(b *Build) { b.SHASumsSignatureURL = "" },
+			wantErr: true,
+		},
+		{
+			name:    "signature not found",
+			key:     trusted,
+			modify:  func(b *Build) { b.SHASumsSignatureURL = b.URL + "-missing" },
+			wantErr: true,
+		},
+		{
+			name: "missing SHA256SUMS",
+			key:  trusted,
+			modify: func(b *Build) {
+				sum := sha256.Sum256(archive)
+				b.SHASumsURL, b.SHASumsSignatureURL, b.SHASum = "", "", hex.EncodeToString(sum[:])
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetReleaseSigningKeys("example", trusted.Armored)
+			SetTrustRegistrySigningKeys(tt.registry)
+
+			build := serveSignedBuild(t, archive, tt.key)
+			if tt.modify != nil {
+				tt.modify(build)
+			}
+
+			_, err := downloadBuildE(context.Background(), "example", build, t.TempDir())
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var sigErr *SignatureError
+			var httpErr *HTTPError
+			if !errors.As(err, &sigErr) && !errors.As(err, &httpErr) {
+				t.Fatalf("expected a signature error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloadBuildWithoutTrustedKeys(t *testing.T) {
+	restoreSigningKeys(t)
+	SetReleaseSigningKeys("example")
+
+	archive := newTestZip(t, "terraform", "binary")
+	build := serveSignedBuild(t, archive, newTestSigningKey(t))
+	build.SigningKeys = []string{newTestSigningKey(t).Armored}
+
+	if _, err := downloadBuildE(context.Background(), "example", build, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	build.SHASum = strings.Repeat("0", 64)
+	_, err := downloadBuildE(context.Background(), "example", build, t.TempDir())
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+}
+
+func TestReleaseSigningKeysMatchProviders(t *testing.T) {
+	restoreSigningKeys(t)
+
+	key := newTestSigningKey(t)
+	SetReleaseSigningKeys("example/test", key.Armored)
+
+	for _, release := range []string{"example/test", "registry.terraform.io/example/test", "example.com/example/test"} {
+		if keys := getTrustedSigningKeys(release, &Build{}); len(keys) != 1 {
+			t.Errorf("expected the key to be trusted for %s, got %d keys", release, len(keys))
+		}
+	}
+
+	if keys := getTrustedSigningKeys("example/other", &Build{}); len(keys) != 0 {
+		t.Errorf("expected no keys to be trusted for example/other, got %d", len(keys))
+	}
+
+	if keys := getTrustedSigningKeys("terraform", &Build{}); len(keys) != 1 {
+		t.Errorf("expected the HashiCorp key to be trusted for terraform, got %d keys", len(keys))
+	}
+}
+
+func TestDownloadEngineRequiresSigningKeys(t *testing.T) {
+	restoreSigningKeys(t)
+	useTestCache(t)
+
+	key := newTestSigningKey(t)
+	dir := t.TempDir()
+	platform := CurrentPlatform()
+	versionDir := writeTestRelease(t, dir, "example", "1.0.0", platform, "example 1.0.0")
+	signTestRelease(t, key, versionDir, "example", "1.0.0")
+
+	server := StartReleasesAPI(t, dir)
+	engine := Engine{Name: "example", Release: "example", ReleaseSource: &HashicorpReleaseSource{BaseURL: server.URL}}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		optOut  bool
+		wantErr string
+	}{
+		{name: "no keys", wantErr: "no signing key is trusted for example"},
+		{name: "trusted key", keys: []string{key.Armored}},
+		{name: "opted out", optOut: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestCache(t)
+			if tt.optOut || len(tt.keys) > 0 {
+				SetReleaseSigningKeys("example", tt.keys...)
+			}
+
+			_, err := DownloadEngineVersionContextE(context.Background(), engine, "1.0.0", platform)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDownloadEngineFetchesSHA256SumsOnce(t *testing.T) {
+	restoreSigningKeys(t)
+	useTestCache(t)
+
+	key := newTestSigningKey(t)
+	SetReleaseSigningKeys("terraform", key.Armored)
+
+	dir := t.TempDir()
+	platform := CurrentPlatform()
+	versionDir := writeTestRelease(t, dir, "terraform", "1.5.7", platform, "terraform 1.5.7")
+	signTestRelease(t, key, versionDir, "terraform", "1.5.7")
+
+	var fetches int32
+	api := NewReleasesAPI(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "_SHA256SUMS") {
+			atomic.AddInt32(&fetches, 1)
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	engine := TerraformEngine()
+	engine.ReleaseSource = &HashicorpReleaseSource{BaseURL: server.URL}
+	if _, err := DownloadEngineVersionContextE(context.Background(), engine, "1.5.7", platform); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("expected the SHA256SUMS file to be fetched once, got %d", got)
+	}
+}
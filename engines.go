@@ -0,0 +1,280 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Engine is a Terraform compatible binary the version matrix tests can run, such as Terraform
+// itself or OpenTofu.
+type Engine struct {
+	// Name is used to name the subtests that run the engine.
+	Name string
+	// Release is the name of the engine's releases in its ReleaseSource, which is also the name
+	// of the binary in its release archives and in the cache.
+	Release string
+	// ReleaseSource lists and resolves the engine's releases. The package level ReleaseSource is
+	// used when it is nil.
+	ReleaseSource ReleaseSource
+	// RegistryHostname is the registry the engine installs providers from when their source
+	// address doesn't name one. DefaultRegistryHostname is used when it is empty.
+	RegistryHostname string
+}
+
+// TerraformEngine returns the Engine for HashiCorp Terraform, resolved with the package level
+// ReleaseSource.
+func TerraformEngine() Engine {
+	return Engine{Name: "terraform", Release: "terraform"}
+}
+
+// OpenTofuEngine returns the Engine for OpenTofu, resolved against the OpenTofu releases and
+// installing providers from the OpenTofu registry. No key is trusted to sign OpenTofu releases by default,
+// so trust OpenTofu's signing key with SetReleaseSigningKeys("tofu", key) before downloading it.
+func OpenTofuEngine() Engine {
+	return Engine{
+		Name:             "tofu",
+		Release:          "tofu",
+		ReleaseSource:    NewOpenTofuReleaseSource(),
+		RegistryHostname: "registry.opentofu.org",
+	}
+}
+
+// GetReleaseSource returns the ReleaseSource the engine's releases are resolved with.
+func (e Engine) GetReleaseSource() ReleaseSource {
+	if e.ReleaseSource != nil {
+		return e.ReleaseSource
+	}
+	return GetReleaseSource()
+}
+
+// qualifyProviderSource prefixes the source address with the engine's registry hostname when it
+// doesn't already name a registry, as the engine itself would.
+func (e Engine) qualifyProviderSource(sourceAddress string) string {
+	if e.RegistryHostname == "" || strings.Count(sourceAddress, "/") != 1 {
+		return sourceAddress
+	}
+	return e.RegistryHostname + "/" + sourceAddress
+}
+
+// GetAvailableEngineVersionsE returns all of the released versions of the engine in ascending
+// semver order, or returns an error if something goes wrong.
+func GetAvailableEngineVersionsE(engine Engine) ([]string, error) {
+	return GetAvailableEngineVersionsContextE(context.Background(), engine)
+}
+
+// GetAvailableEngineVersionsContextE returns all of the released versions of the engine in
+// ascending semver order, stopping when ctx is done. Listings are cached on disk for the
+// version cache TTL, see SetVersionCacheTTL.
+//
+// Usage:
+// * ctx is the context of the requests.
+// * engine is the engine whose releases to list.
+func GetAvailableEngineVersionsContextE(ctx context.Context, engine Engine) ([]string, error) {
+	return listVersionsE(ctx, engine.GetReleaseSource(), engine.Release)
+}
+
+// GetAvailableEngineVersions returns all of the released versions of the engine, or fails the
+// test if something goes wrong.
+func GetAvailableEngineVersions(t *testing.T, engine Engine) []string {
+	versions, err := GetAvailableEngineVersionsContextE(testContext(t), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+// DownloadEngineVersionE will download the specified version of the engine built for the given
+// platform into the Terraform cache, alongside the Terraform binaries. Binaries are kept at
+// <release>_<version> in the cache, or <os>_<arch>/<release>_<version> for platforms other
+// than the one the tests are running on.
+//
+// Usage:
+// * engine is the engine to download, such as OpenTofuEngine().
+// * version is the version of the engine to download.
+// * platform is the operating system and architecture to download the engine for.
+func DownloadEngineVersionE(engine Engine, version string, platform Platform) (binaryPath string, err error) {
+	return DownloadEngineVersionContextE(context.Background(), engine, version, platform)
+}
+
+// DownloadEngineVersionContextE will download the specified version of the engine built for the
+// given platform into the Terraform cache, stopping when ctx is done.
+//
+// Usage:
+// * ctx is the context of the download.
+// * engine is the engine to download, such as OpenTofuEngine().
+// * version is the version of the engine to download.
+// * platform is the operating system and architecture to download the engine for.
+func DownloadEngineVersionContextE(ctx context.Context, engine Engine, version string, platform Platform) (binaryPath string, err error) {
+	binaryDownloadDirectory := GetCache().TerraformDir
+	binaryPath = engineBinaryPath(binaryDownloadDirectory, engine.Release, version, platform)
+
+	if err := os.MkdirAll(binaryDownloadDirectory, os.ModeDir|0o755); err != nil {
+		return "", err
+	}
+
+	err = ensureArtifactE(ctx, binaryDownloadDirectory, binaryPath, func(tmpDir string) (string, error) {
+		if err := requireSigningKeysE(engine.Release); err != nil {
+			return "", err
+		}
+
+		build, err := engine.GetReleaseSource().GetBuild(ctx, engine.Release, version, platform.OS, platform.Arch)
+		if err != nil {
+			return "", fmt.Errorf("Unable to find an appropriate %s binary download URL for %s: %w", engine.Name, platform, err)
+		}
+
+		zipPath, err := downloadBuildE(ctx, engine.Release, build, tmpDir)
+		if err != nil {
+			return "", err
+		}
+
+		return extractEngineBinaryE(zipPath, tmpDir, engine.Release, platform)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return binaryPath, nil
+}
+
+// DownloadEngineVersion will download the specified version of the engine for the platform the
+// tests are running on into the Terraform cache, or fail the test if something goes wrong.
+//
+// Usage:
+// * engine is the engine to download, such as OpenTofuEngine().
+// * version is the version of the engine to download.
+func DownloadEngineVersion(t *testing.T, engine Engine, version string) string {
+	binaryPath, err := DownloadEngineVersionContextE(testContext(t), engine, version, CurrentPlatform())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return binaryPath
+}
+
+// engineBinaryPath returns where the binary of the release version for the platform is kept in
+// the Terraform cache directory.
+func engineBinaryPath(dir, release, version string, platform Platform) string {
+	if platform != CurrentPlatform() {
+		return filepath.Join(dir, platform.String(), release+"_"+version)
+	}
+	return filepath.Join(dir, release+"_"+version)
+}
+
+// extractEngineBinaryE extracts a release archive into tmpDir and returns the path of the
+// binary named after the release it contains.
+func extractEngineBinaryE(zipPath, tmpDir, release string, platform Platform) (string, error) {
+	zipExtractPath := filepath.Join(tmpDir, "bin")
+	if err := extractZipE(zipPath, zipExtractPath); err != nil {
+		return "", err
+	}
+
+	binaryName := release
+	if platform.OS == "windows" {
+		binaryName += ".exe"
+	}
+
+	return filepath.Join(zipExtractPath, binaryName), nil
+}
+
+// OpenTofuReleaseSource is a ReleaseSource for OpenTofu, which lists its releases at
+// get.opentofu.org and publishes them on GitHub.
+type OpenTofuReleaseSource struct {
+	// APIURL is the release index, https://get.opentofu.org/tofu/api.json by default.
+	APIURL string
+	// DownloadURL is the root the release files are downloaded from, with a v<version>
+	// directory for each release.
+	DownloadURL string
+}
+
+// NewOpenTofuReleaseSource returns a ReleaseSource for the published OpenTofu releases.
+func NewOpenTofuReleaseSource() *OpenTofuReleaseSource {
+	return &OpenTofuReleaseSource{
+		APIURL:      "https://get.opentofu.org/tofu/api.json",
+		DownloadURL: "https://github.com/opentofu/opentofu/releases/download",
+	}
+}
+
+type openTofuRelease struct {
+	ID    string   `json:"id"`
+	Files []string `json:"files"`
+}
+
+// ListVersions returns every version of OpenTofu in the release index.
+func (s *OpenTofuReleaseSource) ListVersions(ctx context.Context, release string) ([]string, error) {
+	releases, err := s.getReleasesE(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(releases))
+	for _, rel := range releases {
+		versions = append(versions, rel.ID)
+	}
+
+	return versions, nil
+}
+
+// GetBuild returns the build of the OpenTofu version for the os and architecture, with its
+// checksum from the release's SHA256SUMS file.
+func (s *OpenTofuReleaseSource) GetBuild(ctx context.Context, release, version, goos, goarch string) (*Build, error) {
+	releases, err := s.getReleasesE(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range releases {
+		if rel.ID != version {
+			continue
+		}
+
+		files := map[string]bool{}
+		for _, file := range rel.Files {
+			files[file] = true
+		}
+
+		filename := fmt.Sprintf("%s_%s_%s_%s.zip", release, version, goos, goarch)
+		if !files[filename] {
+			break
+		}
+
+		baseURL := fmt.Sprintf("%s/v%s/", strings.TrimSuffix(s.DownloadURL, "/"), version)
+		build := &Build{
+			OS:         goos,
+			Arch:       goarch,
+			Filename:   filename,
+			URL:        baseURL + filename,
+			SHASumsURL: baseURL + sha256SumsFilename(release, version),
+		}
+		if sig := sha256SumsFilename(release, version) + ".gpgsig"; files[sig] {
+			build.SHASumsSignatureURL = baseURL + sig
+		}
+
+		if err := build.fetchSHA256SumsE(ctx); err != nil {
+			return nil, err
+		}
+
+		return build, nil
+	}
+
+	return nil, fmt.Errorf("no %s %s build found for %s_%s", release, version, goos, goarch)
+}
+
+func (s *OpenTofuReleaseSource) getReleasesE(ctx context.Context, release string) ([]openTofuRelease, error) {
+	if release != "tofu" {
+		return nil, fmt.Errorf("%s is not an OpenTofu release", release)
+	}
+
+	var result struct {
+		Versions []openTofuRelease `json:"versions"`
+	}
+
+	if err := getJSON(ctx, s.APIURL, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Versions, nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package testhelpers
+
+import "os"
+
+// lockFile is a no-op on platforms without file locking, where only the in-process
+// deduplication of downloads applies.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without file locking.
+func unlockFile(f *os.File) error {
+	return nil
+}
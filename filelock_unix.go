@@ -0,0 +1,18 @@
+//go:build unix
+
+package testhelpers
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until an exclusive lock on the file is acquired.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases the lock held on the file.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,122 @@
+package testhelpers
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultUserAgent is the User-Agent header sent with every request unless SetUserAgent has
+// been called.
+const DefaultUserAgent = "terraform-testing"
+
+// metadataTimeout bounds requests for release listings, build metadata and checksums, which
+// are small. Downloads are only bounded by their context, as archives can be large.
+const metadataTimeout = 30 * time.Second
+
+// dialTimeout and tlsHandshakeTimeout bound connecting to a server with the default client, so
+// an unreachable host fails the request instead of waiting for the test to time out.
+const (
+	dialTimeout         = 30 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	httpClientMx      sync.RWMutex
+	httpClient        *http.Client
+	defaultHTTPClient = newDefaultHTTPClient()
+	userAgent         = DefaultUserAgent
+)
+
+// newDefaultHTTPClient returns a client using a copy of http.DefaultTransport, which honours
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY, with timeouts for connecting and the TLS handshake.
+func newDefaultHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = tlsHandshakeTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// SetHTTPClient changes the client used for every network request the package makes, such as
+// one with a proxy, custom CA certificates or a recording transport. Use nil to restore the
+// default client, which is based on http.DefaultTransport and so honours HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY, and which times out connecting and the TLS handshake.
+func SetHTTPClient(client *http.Client) {
+	httpClientMx.Lock()
+	defer httpClientMx.Unlock()
+
+	httpClient = client
+}
+
+// GetHTTPClient returns the client used for every network request the package makes.
+func GetHTTPClient() *http.Client {
+	httpClientMx.RLock()
+	defer httpClientMx.RUnlock()
+
+	if httpClient == nil {
+		return defaultHTTPClient
+	}
+	return httpClient
+}
+
+// SetUserAgent changes the User-Agent header sent with every request.
+func SetUserAgent(ua string) {
+	httpClientMx.Lock()
+	defer httpClientMx.Unlock()
+
+	userAgent = ua
+}
+
+// doGetE sends a GET request for the URL with the configured client and User-Agent, returning
+// the response if it is successful. Any other response is closed and returned as an *HTTPError
+// describing it.
+func doGetE(ctx context.Context, req string) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClientMx.RLock()
+	r.Header.Set("User-Agent", userAgent)
+	httpClientMx.RUnlock()
+
+	resp, err := GetHTTPClient().Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		return nil, &HTTPError{
+			URL:        req,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	return resp, nil
+}
+
+// testContext returns a context that is cancelled when the test finishes, with the test's
+// deadline if it has one, for the network requests made on behalf of a test.
+func testContext(t *testing.T) context.Context {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := t.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	t.Cleanup(cancel)
+
+	return ctx
+}
@@ -0,0 +1,44 @@
+package testhelpers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDefaultHTTPClient(t *testing.T) {
+	transport, ok := GetHTTPClient().Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected the default client to use an *http.Transport, got %T", GetHTTPClient().Transport)
+	}
+	if transport.TLSHandshakeTimeout != tlsHandshakeTimeout {
+		t.Errorf("expected a TLS handshake timeout of %s, got %s", tlsHandshakeTimeout, transport.TLSHandshakeTimeout)
+	}
+	if transport.DialContext == nil {
+		t.Error("expected the default client to dial with a timeout")
+	}
+	if transport.Proxy == nil {
+		t.Error("expected the default client to honour the proxy environment variables")
+	}
+}
+
+func TestTestContext(t *testing.T) {
+	var ctx context.Context
+	t.Run("subtest", func(t *testing.T) {
+		c := testContext(t)
+
+		deadline, hasDeadline := t.Deadline()
+		ctxDeadline, ctxHasDeadline := c.Deadline()
+		if hasDeadline != ctxHasDeadline || !deadline.Equal(ctxDeadline) {
+			t.Errorf("expected the test's deadline %s, got %s", deadline, ctxDeadline)
+		}
+		if c.Err() != nil {
+			t.Fatalf("expected the context to be live during the test, got %v", c.Err())
+		}
+		ctx = c
+	})
+
+	if ctx.Err() == nil {
+		t.Error("expected the context to be cancelled when the test finished")
+	}
+}
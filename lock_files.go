@@ -0,0 +1,162 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// lockFileHeader is the comment Terraform writes at the top of every dependency lock file.
+const lockFileHeader = `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+`
+
+// ProviderLock is the entry for a single provider in a dependency lock file.
+type ProviderLock struct {
+	// Source is the provider's source address. It is written fully qualified.
+	Source      string
+	Version     string
+	Constraints string
+	Hashes      []string
+}
+
+// GetProviderLockE returns the dependency lock entry for the provider version, with hashes for
+// each of the given platforms, downloading the provider into the cache as needed.
+//
+// Usage:
+// * sourceAddress is the sourceAddress of the provider.
+// * version is the version of the provider to lock.
+// * constraints is the version constraint the module places on the provider.
+// * platforms are the operating systems and architectures the lock should be valid on.
+func GetProviderLockE(sourceAddress, version, constraints string, platforms []Platform) (ProviderLock, error) {
+	return GetProviderLockContextE(context.Background(), sourceAddress, version, constraints, platforms)
+}
+
+// GetProviderLockContextE returns the dependency lock entry for the provider version, with hashes
+// for each of the given platforms, stopping when ctx is done.
+//
+// Usage:
+// * ctx is the context of the downloads.
+// * sourceAddress is the sourceAddress of the provider.
+// * version is the version of the provider to lock.
+// * constraints is the version constraint the module places on the provider.
+// * platforms are the operating systems and architectures the lock should be valid on.
+func GetProviderLockContextE(ctx context.Context, sourceAddress, version, constraints string, platforms []Platform) (ProviderLock, error) {
+	hashes, err := GetProviderHashesContextE(ctx, version, sourceAddress, platforms)
+	if err != nil {
+		return ProviderLock{}, err
+	}
+
+	return ProviderLock{
+		Source:      sourceAddress,
+		Version:     version,
+		Constraints: constraints,
+		Hashes:      hashes,
+	}, nil
+}
+
+// WriteLockFileE writes a .terraform.lock.hcl file containing the given provider locks into
+// dir, replacing any lock file already there.
+//
+// Usage:
+// * dir is the directory that contains the Terraform source files.
+// * locks are the providers to record in the lock file.
+func WriteLockFileE(dir string, locks []ProviderLock) error {
+	type entry struct {
+		source string
+		lock   ProviderLock
+	}
+
+	entries := make([]entry, 0, len(locks))
+	for _, lock := range locks {
+		hostname, namespace, providerType, err := ParseProviderSourceE(lock.Source)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{source: strings.Join([]string{hostname, namespace, providerType}, "/"), lock: lock})
+	}
+
+	// Terraform orders the providers in a lock file by their source address.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].source < entries[j].source
+	})
+
+	f := hclwrite.NewEmptyFile()
+	for i, e := range entries {
+		if i > 0 {
+			f.Body().AppendNewline()
+		}
+
+		body := f.Body().AppendNewBlock("provider", []string{e.source}).Body()
+		body.SetAttributeValue("version", cty.StringVal(e.lock.Version))
+		if e.lock.Constraints != "" {
+			body.SetAttributeValue("constraints", cty.StringVal(e.lock.Constraints))
+		}
+		if len(e.lock.Hashes) > 0 {
+			body.SetAttributeRaw("hashes", lockHashesTokens(e.lock.Hashes))
+		}
+	}
+
+	content := append([]byte(lockFileHeader+"\n"), hclwrite.Format(f.Bytes())...)
+	return os.WriteFile(filepath.Join(dir, ".terraform.lock.hcl"), content, 0o666)
+}
+
+// WriteLockFile writes a .terraform.lock.hcl file containing the given provider locks into
+// dir, or fails the test if something goes wrong.
+//
+// Usage:
+// * dir is the directory that contains the Terraform source files.
+// * locks are the providers to record in the lock file.
+func WriteLockFile(t *testing.T, dir string, locks []ProviderLock) {
+	if err := WriteLockFileE(dir, locks); err != nil {
+		t.Fatalf("error when attempting to write the lock file: %s", err)
+	}
+}
+
+// lockHashesTokens renders the hashes as a list with one hash per line, the way Terraform
+// writes them.
+func lockHashesTokens(hashes []string) hclwrite.Tokens {
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrack, Bytes: []byte("[")},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+	}
+
+	for _, hash := range hashes {
+		tokens = append(tokens, hclwrite.TokensForValue(cty.StringVal(hash))...)
+		tokens = append(tokens,
+			&hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+			&hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+		)
+	}
+
+	return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte("]")})
+}
+
+// lockProvidersE writes a lock file into dir for the given providers, each pinned to the exact
+// version in pins keyed by local name, with hashes for the given platforms. The constraints
+// recorded for each provider are those the module in dir declares, as Terraform records them.
+func lockProvidersE(ctx context.Context, dir string, sources, pins map[string]string, platforms []Platform) error {
+	var locks []ProviderLock
+	for name, ver := range pins {
+		required, err := GetRequiredProviderE(dir, name)
+		if err != nil {
+			return fmt.Errorf("error when attempting to lock provider %s: %w", name, err)
+		}
+
+		lock, err := GetProviderLockContextE(ctx, sources[name], ver, required.VersionConstraint(), platforms)
+		if err != nil {
+			return fmt.Errorf("error when attempting to lock provider %s: %w", name, err)
+		}
+		locks = append(locks, lock)
+	}
+
+	return WriteLockFileE(dir, locks)
+}
@@ -0,0 +1,397 @@
+package testhelpers
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	teststructure "github.com/gruntwork-io/terratest/modules/test-structure"
+)
+
+// terraformDimension is the name given to the Terraform binary axis of a version matrix.
+const terraformDimension = "tf"
+
+// MatrixOptions configures the version matrix tests. It embeds the terraform.Options used as
+// a template for every subtest, so anything terratest supports (var files, backend config,
+// parallelism, retry patterns, timeouts and so on) can be set on it directly. The template is
+// cloned for each subtest, and TerraformDir and TerraformBinary are set on the clone.
+type MatrixOptions struct {
+	*terraform.Options
+
+	// Engines are the engines to run the Terraform version matrices with, such as
+	// TerraformEngine() and OpenTofuEngine(). Each engine's versions are resolved from its own
+	// releases and its subtests are grouped under the engine's name. Only Terraform is tested,
+	// without the extra level of subtests, when it is empty.
+	Engines []Engine
+
+	// VersionSelector chooses which of the matching versions are tested. Every matching
+	// version is tested when it is nil. A ReleaseSelector, such as SelectReleasedWithin, is
+	// given the release metadata of the versions where their release source publishes it.
+	VersionSelector VersionSelector
+
+	// Pairwise reduces multi-dimensional matrices to a set of combinations that covers every
+	// pair of versions instead of running the full cross product.
+	Pairwise bool
+
+	// LockPlatforms, when set, makes each subtest write a .terraform.lock.hcl file pinning the
+	// providers it tests, with hashes for these platforms as well as the current one, so init
+	// verifies the providers it installs.
+	LockPlatforms []Platform
+
+	// HermeticInit makes each subtest download the providers it tests into the provider cache
+	// and point Terraform at a generated CLI configuration that installs them only from there,
+	// so init doesn't need the network for them once they are cached.
+	HermeticInit bool
+
+	// ProviderMirror, when set, is used to install the providers each subtest tests instead of
+	// their registries, with every other provider installed directly. The providers being
+	// tested are downloaded into the provider cache it serves first, which needs no network
+	// access once they are cached. On macOS, the mirror's directory is used as a filesystem
+	// mirror instead of serving it over HTTPS, see ProviderMirrorServer.EnvVars.
+	ProviderMirror *ProviderMirrorServer
+
+	// PluginCacheDir is written to the generated CLI configuration as plugin_cache_dir, for the
+	// providers still installed directly. It must not be the provider cache directory.
+	PluginCacheDir string
+}
+
+// NewMatrixOptions returns MatrixOptions with the default retry behaviour used by the matrix
+// tests, ready to be customised.
+func NewMatrixOptions(t *testing.T) *MatrixOptions {
+	return &MatrixOptions{Options: newTerraformOptions(t)}
+}
+
+// newMatrixOptionsFromMaps builds MatrixOptions from the variables and environment variables
+// accepted by the original matrix test functions.
+func newMatrixOptionsFromMaps(t *testing.T, variables map[string]interface{}, environment_variables map[string]string) *MatrixOptions {
+	opts := NewMatrixOptions(t)
+
+	if len(variables) > 0 {
+		opts.Vars = variables
+	}
+	if len(environment_variables) > 0 {
+		opts.EnvVars = environment_variables
+	}
+
+	return opts
+}
+
+// cloneTerraformOptions returns a copy of the template options for a single subtest, or
+// fails the test if the options cannot be copied.
+func (opts *MatrixOptions) cloneTerraformOptions(t *testing.T) *terraform.Options {
+	t.Helper()
+
+	if opts == nil || opts.Options == nil {
+		return newTerraformOptions(t)
+	}
+
+	tfOptions, err := opts.Options.Clone()
+	if err != nil {
+		t.Fatalf("error when attempting to clone the terraform options: %s", err)
+	}
+
+	return tfOptions
+}
+
+// selectVersions applies the configured VersionSelector to the given versions, whose release
+// metadata is held in releases, or fails the test if the selection cannot be made.
+func (opts *MatrixOptions) selectVersions(t *testing.T, versions []string, releases map[string]ReleaseMetadata) []string {
+	t.Helper()
+
+	if opts == nil || opts.VersionSelector == nil {
+		return versions
+	}
+
+	selected, err := selectVersionsE(opts.VersionSelector, versions, releases)
+	if err != nil {
+		t.Fatalf("error when attempting to select versions to test: %s", err)
+	}
+
+	return selected
+}
+
+// runEngines runs fn for each of the configured engines, in a parallel subtest named after the
+// engine, or for Terraform alone in the current test when no engines are configured.
+func (opts *MatrixOptions) runEngines(t *testing.T, fn func(t *testing.T, engine Engine)) {
+	if opts == nil || len(opts.Engines) == 0 {
+		fn(t, TerraformEngine())
+		return
+	}
+
+	for _, engine := range opts.Engines {
+		engine := engine
+		t.Run(engine.Name, func(t *testing.T) {
+			t.Parallel()
+
+			fn(t, engine)
+		})
+	}
+}
+
+// combinations returns the combinations of the given version lists to test, which is the full
+// cross product unless pairwise reduction is enabled.
+func (opts *MatrixOptions) combinations(lists [][]string) [][]string {
+	if opts != nil && opts.Pairwise {
+		return PairwiseCombinations(lists)
+	}
+
+	return crossProduct(lists)
+}
+
+// lockProviders writes a lock file into dst for the pinned providers, keyed by local name, when
+// LockPlatforms is set, or fails the test if the lock file cannot be written.
+func (opts *MatrixOptions) lockProviders(t *testing.T, dst string, sources, pins map[string]string) {
+	t.Helper()
+
+	if opts == nil || len(opts.LockPlatforms) == 0 || len(pins) == 0 {
+		return
+	}
+
+	platforms := []Platform{CurrentPlatform()}
+	for _, platform := range opts.LockPlatforms {
+		if platform != CurrentPlatform() {
+			platforms = append(platforms, platform)
+		}
+	}
+
+	if err := lockProvidersE(testContext(t), dst, sources, pins, platforms); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// configureProviderInstallation installs the pinned providers, keyed by local name, into the
+// provider cache and sets TF_CLI_CONFIG_FILE on tfOptions to a CLI configuration that installs
+// them from there, and every other provider directly, when HermeticInit or ProviderMirror is
+// set.
+func (opts *MatrixOptions) configureProviderInstallation(t *testing.T, tfOptions *terraform.Options, sources, pins map[string]string) {
+	t.Helper()
+
+	if opts == nil || (!opts.HermeticInit && opts.ProviderMirror == nil) {
+		return
+	}
+
+	sourceAddresses := []string{}
+	for name, ver := range pins {
+		DownloadProviderVersionForPlatforms(t, ver, sources[name], []Platform{CurrentPlatform()})
+		sourceAddresses = append(sourceAddresses, sources[name])
+	}
+
+	filename := filepath.Join(t.TempDir(), "terraform.rc")
+	envVars := map[string]string{CLIConfigEnvVar: filename}
+	if opts.ProviderMirror != nil {
+		envVars = opts.ProviderMirror.EnvVars(t, filename, CLIConfig{PluginCacheDir: opts.PluginCacheDir}, sourceAddresses)
+	} else {
+		cfg := NewHermeticCLIConfig(sourceAddresses)
+		cfg.PluginCacheDir = opts.PluginCacheDir
+		WriteCLIConfig(t, filename, cfg)
+	}
+
+	if tfOptions.EnvVars == nil {
+		tfOptions.EnvVars = map[string]string{}
+	}
+	for k, v := range envVars {
+		tfOptions.EnvVars[k] = v
+	}
+}
+
+// MatrixDimension is a single axis of a version matrix, either the Terraform binary or one
+// provider.
+type MatrixDimension struct {
+	// Name is "tf" for the Terraform binary, or the provider's local name. It only names the
+	// subtests, so a provider may be called "tf" too.
+	Name string
+	// IsEngine is true for the dimension varying the engine's binary, and false for providers.
+	IsEngine bool
+	// Engine is the engine whose binary the engine dimension varies. Terraform is used when it
+	// is the zero value.
+	Engine Engine
+	// Source is the provider's source address. It is empty for the Terraform binary.
+	Source string
+	// Versions are the versions to test along this axis.
+	Versions []string
+	// Blocked are the matching versions left out of Versions because they are in the
+	// Blocklist, with the entry blocking each. They are reported as skipped subtests.
+	Blocked map[string]BlockedVersion
+	// Releases holds the release metadata of the versions, keyed by their normalised version,
+	// for the VersionSelectors that choose versions by it.
+	Releases map[string]ReleaseMetadata
+}
+
+// TerraformProviderMatrixTest runs InitAndPlan for every combination of the Terraform versions
+// matching the module's required_version constraint and the versions of each of the given
+// providers matching their required_providers constraints. Subtests are nested per axis and
+// named like "tf=1.5.7/aws=5.31.0". Set Pairwise on opts to test a reduced set of
+// combinations that covers every pair of versions. Versions in the Blocklist are reported as
+// skipped subtests named like "tf=1.10.0".
+//
+// Usage:
+//   - srcDir is the directory that contains the Terraform module to test.
+//   - providers are the local names of the providers to vary alongside Terraform.
+//   - opts is the template for each subtest's terraform.Options.
+func TerraformProviderMatrixTest(t *testing.T, srcDir string, providers []string, opts *MatrixOptions) {
+	opts.runEngines(t, func(t *testing.T, engine Engine) {
+		dims := GetEngineMatrixDimensions(t, srcDir, engine, providers)
+		for i := range dims {
+			skipBlockedVersions(t, dims[i].Name+"=", dims[i].release(), dims[i].Blocked)
+			dims[i].Versions = opts.selectVersions(t, dims[i].Versions, dims[i].Releases)
+		}
+
+		RunMatrix(t, srcDir, dims, opts.combinations(matrixVersionLists(dims)), opts)
+	})
+}
+
+// GetMatrixDimensions returns the Terraform dimension followed by one dimension for each of
+// the given providers, with the versions matching the module's constraints.
+func GetMatrixDimensions(t *testing.T, srcDir string, providers []string) []MatrixDimension {
+	return GetEngineMatrixDimensions(t, srcDir, TerraformEngine(), providers)
+}
+
+// GetEngineMatrixDimensions returns the dimension of the engine's versions followed by one
+// dimension for each of the given providers, with the versions matching the module's
+// constraints. Providers are resolved against the registry the engine installs them from, and
+// blocked versions are split out into each dimension's Blocked versions.
+func GetEngineMatrixDimensions(t *testing.T, srcDir string, engine Engine, providers []string) []MatrixDimension {
+	matching := getEngineMatrixVersions(t, srcDir, engine)
+	dims := []MatrixDimension{{
+		Name:     terraformDimension,
+		IsEngine: true,
+		Engine:   engine,
+		Versions: matching.versions,
+		Blocked:  matching.blocked,
+		Releases: matching.releases,
+	}}
+
+	for _, provider := range providers {
+		matching := getProviderMatrixVersions(t, srcDir, provider, engine)
+		dims = append(dims, MatrixDimension{
+			Name:     provider,
+			Source:   matching.release,
+			Versions: matching.versions,
+			Blocked:  matching.blocked,
+			Releases: matching.releases,
+		})
+	}
+
+	return dims
+}
+
+// RunMatrix runs InitAndPlan for each of the given combinations of versions. Every
+// combination holds one version per dimension, in the same order as dims. The test fails when
+// any dimension has no versions, rather than passing without running anything.
+func RunMatrix(t *testing.T, srcDir string, dims []MatrixDimension, combinations [][]string, opts *MatrixOptions) {
+	for _, dim := range dims {
+		if len(dim.Versions) == 0 {
+			t.Fatalf("no %s versions to test match the module's constraints", dim.Name)
+		}
+	}
+
+	for _, combination := range combinations {
+		if len(combination) != len(dims) {
+			t.Fatalf("matrix combination %v does not have a version for each of the %d dimensions", combination, len(dims))
+		}
+	}
+
+	runMatrixLevel(t, dims, combinations, 0, func(t *testing.T, combination []string) {
+		runMatrixCell(t, srcDir, dims, combination, opts)
+	})
+}
+
+// runMatrixLevel groups the combinations by their version at the given depth and runs a
+// parallel subtest for each group, recursing until every dimension has been named.
+func runMatrixLevel(t *testing.T, dims []MatrixDimension, combinations [][]string, depth int, fn func(t *testing.T, combination []string)) {
+	if depth == len(dims) {
+		for _, combination := range combinations {
+			fn(t, combination)
+		}
+		return
+	}
+
+	var order []string
+	groups := map[string][][]string{}
+	for _, combination := range combinations {
+		ver := combination[depth]
+		if _, ok := groups[ver]; !ok {
+			order = append(order, ver)
+		}
+		groups[ver] = append(groups[ver], combination)
+	}
+
+	for _, ver := range order {
+		group := groups[ver]
+		t.Run(fmt.Sprintf("%s=%s", dims[depth].Name, ver), func(t *testing.T) {
+			t.Parallel()
+
+			runMatrixLevel(t, dims, group, depth+1, fn)
+		})
+	}
+}
+
+// runMatrixCell copies the module to a temporary directory, pins the versions of a single
+// combination and runs InitAndPlan against it.
+func runMatrixCell(t *testing.T, srcDir string, dims []MatrixDimension, combination []string, opts *MatrixOptions) {
+	tfOptions := opts.cloneTerraformOptions(t)
+	dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, "")
+	UpdateModuleSourcesToLocalPaths(t, dst)
+
+	sources := map[string]string{}
+	pins := map[string]string{}
+	for i, dim := range dims {
+		if dim.IsEngine {
+			tfOptions.TerraformBinary = DownloadEngineVersion(t, dim.engine(), combination[i])
+			continue
+		}
+
+		UpdateProviderVersion(t, dst, dim.Name, combination[i], dim.Source)
+		sources[dim.Name] = dim.Source
+		pins[dim.Name] = combination[i]
+	}
+
+	opts.lockProviders(t, dst, sources, pins)
+	opts.configureProviderInstallation(t, tfOptions, sources, pins)
+	tfOptions.TerraformDir = dst
+	terraform.InitAndPlan(t, tfOptions)
+}
+
+// engine returns the engine of the dimension, defaulting to Terraform.
+func (dim MatrixDimension) engine() Engine {
+	if dim.Engine.Release == "" {
+		return TerraformEngine()
+	}
+	return dim.Engine
+}
+
+// release returns the engine's release name for the engine dimension, or the provider's source
+// address, as used to key the Blocklist.
+func (dim MatrixDimension) release() string {
+	if dim.IsEngine {
+		return dim.engine().Release
+	}
+	return dim.Source
+}
+
+// matrixVersionLists returns the versions of each dimension, in order.
+func matrixVersionLists(dims []MatrixDimension) [][]string {
+	lists := make([][]string, 0, len(dims))
+	for _, dim := range dims {
+		lists = append(lists, dim.Versions)
+	}
+	return lists
+}
+
+// crossProduct returns every combination that takes one value from each of the given lists.
+func crossProduct(lists [][]string) [][]string {
+	combinations := [][]string{{}}
+	for _, list := range lists {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, val := range list {
+				c := make([]string, len(combination), len(combination)+1)
+				copy(c, combination)
+				next = append(next, append(c, val))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
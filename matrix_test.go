@@ -0,0 +1,35 @@
+package testhelpers
+
+import "testing"
+
+func TestMatrixDimensionRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		dim  MatrixDimension
+		want string
+	}{
+		{
+			name: "terraform",
+			dim:  MatrixDimension{Name: terraformDimension, IsEngine: true},
+			want: "terraform",
+		},
+		{
+			name: "opentofu",
+			dim:  MatrixDimension{Name: terraformDimension, IsEngine: true, Engine: OpenTofuEngine()},
+			want: OpenTofuEngine().Release,
+		},
+		{
+			name: "provider named tf",
+			dim:  MatrixDimension{Name: terraformDimension, Source: "example.com/example/tf"},
+			want: "example.com/example/tf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dim.release(); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package testhelpers
+
+// dontCare marks a position in a pairwise combination that no pair depends on yet.
+const dontCare = -1
+
+// PairwiseCombinations returns a set of combinations, each taking one value from every one of
+// the given lists, such that every pair of values from any two lists appears together in at
+// least one combination. This is usually far smaller than the full cross product, making it
+// practical to test modules that depend on several providers at once.
+//
+// The combinations are generated with the IPOG strategy, so the result is deterministic for
+// the same input but not guaranteed to be the smallest possible set.
+func PairwiseCombinations(lists [][]string) [][]string {
+	for _, list := range lists {
+		if len(list) == 0 {
+			return nil
+		}
+	}
+
+	if len(lists) < 3 {
+		return crossProduct(lists)
+	}
+
+	// Start with every combination of the first two lists, then extend each row in turn.
+	var rows [][]int
+	for a := range lists[0] {
+		for b := range lists[1] {
+			row := newPairwiseRow(len(lists))
+			row[0], row[1] = a, b
+			rows = append(rows, row)
+		}
+	}
+
+	for i := 2; i < len(lists); i++ {
+		uncovered := map[pairwiseKey]bool{}
+		for j := 0; j < i; j++ {
+			for a := range lists[j] {
+				for b := range lists[i] {
+					uncovered[pairwiseKey{j, a, b}] = true
+				}
+			}
+		}
+
+		// Horizontal growth: give each existing row the value for this list that covers the
+		// most pairs that are not covered yet.
+		for r, row := range rows {
+			best, bestCovered := r%len(lists[i]), -1
+			for n := range lists[i] {
+				b := (r + n) % len(lists[i])
+				covered := 0
+				for j := 0; j < i; j++ {
+					if row[j] != dontCare && uncovered[pairwiseKey{j, row[j], b}] {
+						covered++
+					}
+				}
+				if covered > bestCovered {
+					best, bestCovered = b, covered
+				}
+			}
+
+			row[i] = best
+			for j := 0; j < i; j++ {
+				if row[j] != dontCare {
+					delete(uncovered, pairwiseKey{j, row[j], best})
+				}
+			}
+		}
+
+		// Vertical growth: cover any remaining pairs by filling in unused positions of
+		// existing rows, or by adding new rows when there are none.
+		for j := 0; j < i; j++ {
+			for a := range lists[j] {
+				for b := range lists[i] {
+					if !uncovered[pairwiseKey{j, a, b}] {
+						continue
+					}
+
+					placed := false
+					for _, row := range rows {
+						if row[i] == b && row[j] == dontCare {
+							row[j] = a
+							placed = true
+							break
+						}
+					}
+
+					if !placed {
+						row := newPairwiseRow(len(lists))
+						row[j], row[i] = a, b
+						rows = append(rows, row)
+					}
+
+					delete(uncovered, pairwiseKey{j, a, b})
+				}
+			}
+		}
+	}
+
+	combinations := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		combination := make([]string, len(lists))
+		for k, v := range row {
+			if v == dontCare {
+				v = 0
+			}
+			combination[k] = lists[k][v]
+		}
+		combinations = append(combinations, combination)
+	}
+
+	return combinations
+}
+
+// pairwiseKey identifies a pair of values: value a of list j together with value b of the list
+// currently being added.
+type pairwiseKey struct {
+	j, a, b int
+}
+
+func newPairwiseRow(n int) []int {
+	row := make([]int, n)
+	for k := range row {
+		row[k] = dontCare
+	}
+	return row
+}
@@ -0,0 +1,83 @@
+package testhelpers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testVersionLists returns a version list of the given length for each dimension.
+func testVersionLists(sizes ...int) [][]string {
+	lists := make([][]string, 0, len(sizes))
+	for i, size := range sizes {
+		list := make([]string, 0, size)
+		for v := 0; v < size; v++ {
+			list = append(list, fmt.Sprintf("%d.%d.0", i, v))
+		}
+		lists = append(lists, list)
+	}
+	return lists
+}
+
+func TestPairwiseCombinations(t *testing.T) {
+	tests := []struct {
+		sizes []int
+	}{
+		{sizes: []int{2, 2, 2}},
+		{sizes: []int{3, 3, 3}},
+		{sizes: []int{4, 1, 3}},
+		{sizes: []int{2, 5, 3}},
+		{sizes: []int{3, 3, 3, 3}},
+		{sizes: []int{5, 2, 4, 3}},
+		{sizes: []int{1, 4, 2, 5}},
+		{sizes: []int{3, 3, 3, 3, 3}},
+		{sizes: []int{4, 2, 5, 1, 3}},
+		{sizes: []int{2, 6, 3, 4, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Trim(strings.ReplaceAll(fmt.Sprint(tt.sizes), " ", "x"), "[]"), func(t *testing.T) {
+			lists := testVersionLists(tt.sizes...)
+			combinations := PairwiseCombinations(lists)
+
+			type pair struct {
+				i, j int
+				a, b string
+			}
+
+			covered := map[pair]bool{}
+			for _, combination := range combinations {
+				if len(combination) != len(lists) {
+					t.Fatalf("combination %v does not have a value for each of the %d lists", combination, len(lists))
+				}
+				for i := range combination {
+					for j := i + 1; j < len(combination); j++ {
+						covered[pair{i, j, combination[i], combination[j]}] = true
+					}
+				}
+			}
+
+			for i := range lists {
+				for j := i + 1; j < len(lists); j++ {
+					for _, a := range lists[i] {
+						for _, b := range lists[j] {
+							if !covered[pair{i, j, a, b}] {
+								t.Errorf("pair %s, %s of lists %d and %d is not covered", a, b, i, j)
+							}
+						}
+					}
+				}
+			}
+
+			if full := len(crossProduct(lists)); len(combinations) > full {
+				t.Errorf("expected at most the %d combinations of the cross product, got %d", full, len(combinations))
+			}
+		})
+	}
+}
+
+func TestPairwiseCombinationsEmptyList(t *testing.T) {
+	if combinations := PairwiseCombinations(testVersionLists(2, 0, 3)); combinations != nil {
+		t.Errorf("expected no combinations, got %v", combinations)
+	}
+}
@@ -0,0 +1,284 @@
+package testhelpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Platform is an operating system and architecture that Terraform and providers are built
+// for, such as linux_amd64.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+// String returns the platform in the <os>_<arch> form used by Terraform.
+func (p Platform) String() string {
+	return p.OS + "_" + p.Arch
+}
+
+// CurrentPlatform returns the platform of the running process.
+func CurrentPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// ParsePlatformE parses a platform in the <os>_<arch> form, such as darwin_arm64, or returns
+// an error if it is not in that form.
+func ParsePlatformE(platform string) (Platform, error) {
+	goos, goarch, ok := strings.Cut(platform, "_")
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "_") {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected <os>_<arch>", platform)
+	}
+
+	return Platform{OS: goos, Arch: goarch}, nil
+}
+
+// ParsePlatforms parses platforms in the <os>_<arch> form, or fails the test if any of them
+// are not in that form.
+func ParsePlatforms(t *testing.T, platforms ...string) []Platform {
+	parsed := make([]Platform, 0, len(platforms))
+	for _, platform := range platforms {
+		p, err := ParsePlatformE(platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed
+}
+
+// GetTerraformBinaryUrlForPlatformE will return the download URL for the Terraform binary version
+// requested for the given platform, rather than the one the tests are running on.
+//
+// Usage:
+// * version is the version of Terraform to download.
+// * platform is the operating system and architecture to download Terraform for.
+func GetTerraformBinaryUrlForPlatformE(version string, platform Platform) (string, error) {
+	build, err := GetReleaseSource().GetBuild(context.Background(), "terraform", version, platform.OS, platform.Arch)
+	if err != nil {
+		return "", err
+	}
+
+	return build.URL, nil
+}
+
+// DownloadTerraformVersionForPlatformE will download the specified version of Terraform built
+// for the given platform into the Terraform cache. Binaries for the platform the tests are
+// running on are kept at terraform_<version> in the cache, and binaries for any other platform
+// at <os>_<arch>/terraform_<version>.
+//
+// Usage:
+// * version is the version of Terraform to download.
+// * platform is the operating system and architecture to download Terraform for.
+func DownloadTerraformVersionForPlatformE(version string, platform Platform) (binaryPath string, err error) {
+	return DownloadEngineVersionE(TerraformEngine(), version, platform)
+}
+
+// DownloadProviderVersionForPlatformsE will download the specified version of the provider built
+// for each of the given platforms into the provider cache, which uses the unpacked layout of a
+// Terraform plugin mirror directory. It returns the cache directory of the provider version.
+//
+// Usage:
+// * version is the version of provider to download.
+// * sourceAddress is the sourceAddress of provider to download.
+// * platforms are the operating systems and architectures to download the provider for.
+func DownloadProviderVersionForPlatformsE(version, sourceAddress string, platforms []Platform) (binaryPath string, err error) {
+	return DownloadProviderVersionForPlatformsContextE(context.Background(), version, sourceAddress, platforms)
+}
+
+// DownloadProviderVersionForPlatformsContextE will download the specified version of the provider
+// built for each of the given platforms into the provider cache, stopping when ctx is done. It
+// returns the cache directory of the provider version.
+//
+// Usage:
+// * ctx is the context of the download.
+// * version is the version of provider to download.
+// * sourceAddress is the sourceAddress of provider to download.
+// * platforms are the operating systems and architectures to download the provider for.
+func DownloadProviderVersionForPlatformsContextE(ctx context.Context, version, sourceAddress string, platforms []Platform) (binaryPath string, err error) {
+	hostname, namespace, providerType, err := ParseProviderSourceE(sourceAddress)
+	if err != nil {
+		return "", err
+	}
+
+	// Initialise all path variables
+	binaryDownloadDirectory := GetBinaryPath()
+	binaryPath = filepath.Join(binaryDownloadDirectory, hostname, namespace, providerType, version)
+
+	// Create the provider cache directory if it doesn't exist
+	if err := os.MkdirAll(binaryDownloadDirectory, os.ModeDir|0o755); err != nil {
+		return "", err
+	}
+
+	for _, platform := range platforms {
+		platform := platform
+		err = ensureArtifactE(ctx, binaryDownloadDirectory, filepath.Join(binaryPath, platform.String()), func(tmpDir string) (string, error) {
+			build, err := GetProviderBuildContextE(ctx, sourceAddress, version, platform.OS, platform.Arch)
+			if err != nil {
+				return "", fmt.Errorf("Error: %w", err)
+			}
+
+			zipPath, err := downloadBuildE(ctx, sourceAddress, build, tmpDir)
+			if err != nil {
+				return "", err
+			}
+
+			zipExtractPath := filepath.Join(tmpDir, "bin")
+			if err := extractZipE(zipPath, zipExtractPath); err != nil {
+				return "", fmt.Errorf("Error: %w", err)
+			}
+
+			return zipExtractPath, nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return binaryPath, nil
+}
+
+// DownloadProviderVersionForPlatforms will download the specified version of the provider built
+// for each of the given platforms into the provider cache, or fail the test if something goes
+// wrong.
+//
+// Usage:
+// * version is the version of provider to download.
+// * sourceAddress is the sourceAddress of provider to download.
+// * platforms are the operating systems and architectures to download the provider for.
+func DownloadProviderVersionForPlatforms(t *testing.T, version, sourceAddress string, platforms []Platform) string {
+	binaryPath, err := DownloadProviderVersionForPlatformsContextE(testContext(t), version, sourceAddress, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return binaryPath
+}
+
+// GetProviderHashesE returns the hashes a dependency lock file records for the provider version
+// on each of the given platforms: the h1 hash of each unpacked package, downloading them into the
+// provider cache as needed, and the zh hash of each published archive. The hashes are sorted.
+//
+// Usage:
+// * version is the version of the provider.
+// * sourceAddress is the sourceAddress of the provider.
+// * platforms are the operating systems and architectures to hash the provider for.
+func GetProviderHashesE(version, sourceAddress string, platforms []Platform) ([]string, error) {
+	return GetProviderHashesContextE(context.Background(), version, sourceAddress, platforms)
+}
+
+// GetProviderHashesContextE returns the dependency lock file hashes of the provider version on
+// each of the given platforms, stopping when ctx is done.
+//
+// Usage:
+// * ctx is the context of the downloads.
+// * version is the version of the provider.
+// * sourceAddress is the sourceAddress of the provider.
+// * platforms are the operating systems and architectures to hash the provider for.
+func GetProviderHashesContextE(ctx context.Context, version, sourceAddress string, platforms []Platform) ([]string, error) {
+	binaryPath, err := DownloadProviderVersionForPlatformsContextE(ctx, version, sourceAddress, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, platform := range platforms {
+		h1, err := hashPackageV1(filepath.Join(binaryPath, platform.String()))
+		if err != nil {
+			return nil, err
+		}
+		seen[h1] = true
+
+		build, err := GetProviderBuildContextE(ctx, sourceAddress, version, platform.OS, platform.Arch)
+		if err != nil {
+			return nil, err
+		}
+
+		sum, err := getExpectedChecksumE(ctx, sourceAddress, build)
+		if err != nil {
+			return nil, err
+		}
+		seen["zh:"+strings.ToLower(sum)] = true
+	}
+
+	hashes := make([]string, 0, len(seen))
+	for hash := range seen {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	return hashes, nil
+}
+
+// GetProviderHashes returns the dependency lock file hashes of the provider version on each of
+// the given platforms, or fails the test if something goes wrong.
+//
+// Usage:
+// * version is the version of the provider.
+// * sourceAddress is the sourceAddress of the provider.
+// * platforms are the operating systems and architectures to hash the provider for.
+func GetProviderHashes(t *testing.T, version, sourceAddress string, platforms []Platform) []string {
+	hashes, err := GetProviderHashesContextE(testContext(t), version, sourceAddress, platforms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hashes
+}
+
+// hashPackageV1 returns the "h1:" hash Terraform uses for an unpacked provider package, which is
+// the Go modules dirhash of every file in the directory.
+func hashPackageV1(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return "", fmt.Errorf("filenames with newlines are not supported: %q", file)
+		}
+
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+
+		fileHash := sha256.New()
+		_, err = io.Copy(fileHash, f)
+		_ = f.Close()
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "%x  %s\n", fileHash.Sum(nil), file)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
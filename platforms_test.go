@@ -0,0 +1,25 @@
+package testhelpers
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHashPackageV1(t *testing.T) {
+	// Terraform's h1 hash is the Go modules dirhash, so the hash of the go-homedir v1.1.0 module
+	// archive published in the Go checksum database is also its h1 hash as a provider package.
+	const want = "h1:lukF9ziXFxDFPkA1vsr5zpc1XuPDn/wFntq5mG+4E0Y="
+
+	dir := t.TempDir()
+	if err := extractZipE(filepath.Join("testdata", "go-homedir-v1.1.0.zip"), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := hashPackageV1(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,112 @@
+package testhelpers
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// PrereleasesEnvVar is the environment variable that includes prereleases in version lists
+// when set to true, for canary runs against upcoming releases.
+const PrereleasesEnvVar = "TERRAFORM_TESTING_PRERELEASES"
+
+// PrereleasePolicy decides whether alpha, beta and rc releases appear in version lists.
+type PrereleasePolicy int
+
+const (
+	// ExcludePrereleases leaves prereleases out of version lists. It is the default.
+	ExcludePrereleases PrereleasePolicy = iota
+	// IncludePrereleases keeps prereleases in version lists, so that matrix tests also run
+	// against upcoming releases. A prerelease matches a constraint when the release it precedes
+	// does, so 1.9.0-rc1 is tested by a module requiring ">= 1.5".
+	IncludePrereleases
+)
+
+var (
+	prereleasePolicyMx sync.RWMutex
+	prereleasePolicy   *PrereleasePolicy
+)
+
+// SetPrereleasePolicy changes whether prereleases appear in the version lists the package
+// returns.
+func SetPrereleasePolicy(policy PrereleasePolicy) {
+	prereleasePolicyMx.Lock()
+	defer prereleasePolicyMx.Unlock()
+
+	prereleasePolicy = &policy
+}
+
+// GetPrereleasePolicy returns whether prereleases appear in the version lists the package
+// returns. Unless SetPrereleasePolicy has been called, they are included only when the
+// TERRAFORM_TESTING_PRERELEASES environment variable is true.
+func GetPrereleasePolicy() PrereleasePolicy {
+	prereleasePolicyMx.RLock()
+	defer prereleasePolicyMx.RUnlock()
+
+	if prereleasePolicy != nil {
+		return *prereleasePolicy
+	}
+
+	if include, _ := strconv.ParseBool(os.Getenv(PrereleasesEnvVar)); include {
+		return IncludePrereleases
+	}
+	return ExcludePrereleases
+}
+
+// ParseVersionsE parses the given version strings and returns them in ascending semver order,
+// so that 1.10.0 sorts after 1.9.0 and 1.9.0-rc1 before 1.9.0, or returns an error if any of
+// them is not a valid version.
+func ParseVersionsE(versions []string) (version.Collection, error) {
+	return parseSortedVersions(versions)
+}
+
+// ParseVersions parses the given version strings and returns them in ascending semver order,
+// or fails the test if any of them is not a valid version.
+func ParseVersions(t *testing.T, versions []string) version.Collection {
+	parsed, err := ParseVersionsE(versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// applyPrereleasePolicy returns the listed releases in ascending semver order, leaving out
+// prereleases unless the policy includes them.
+func applyPrereleasePolicy(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+	parsed := make(map[string]*version.Version, len(releases))
+	for _, release := range releases {
+		v, err := version.NewVersion(release.Version)
+		if err != nil {
+			return nil, err
+		}
+		parsed[release.Version] = v
+	}
+
+	include := GetPrereleasePolicy() == IncludePrereleases
+
+	filtered := make([]ReleaseMetadata, 0, len(releases))
+	for _, release := range releases {
+		if !include && (release.Prerelease || parsed[release.Version].Prerelease() != "") {
+			continue
+		}
+		filtered = append(filtered, release)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return parsed[filtered[i].Version].LessThan(parsed[filtered[j].Version])
+	})
+	return filtered, nil
+}
+
+// checkConstraint reports whether the version satisfies the constraint. Prereleases of a
+// matching release also satisfy it when the prerelease policy includes them.
+func checkConstraint(constraint version.Constraints, v *version.Version) bool {
+	if constraint.Check(v) {
+		return true
+	}
+	return v.Prerelease() != "" && GetPrereleasePolicy() == IncludePrereleases && constraint.Check(v.Core())
+}
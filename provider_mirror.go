@@ -0,0 +1,378 @@
+package testhelpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// SSLCertFileEnvVar is the environment variable Terraform, and the providers it runs, read their
+// trusted certificate authorities from on Linux and other Unix systems. It is ignored on macOS.
+const SSLCertFileEnvVar = "SSL_CERT_FILE"
+
+// systemCABundleFiles are where the trusted certificate authorities of the system are found,
+// in the order Go looks for them.
+var systemCABundleFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux, BSDs
+	"/usr/local/etc/ssl/cert.pem",                       // FreeBSD
+}
+
+// ProviderMirror is an http.Handler implementing the provider network mirror protocol for the
+// providers in a provider cache directory, so that Terraform can install them without access
+// to their registries. Archives are built on the fly from the unpacked packages in the cache.
+type ProviderMirror struct {
+	// Dir is the provider cache directory to serve, in the layout DownloadProviderVersionE
+	// populates.
+	Dir string
+}
+
+// NewProviderMirror returns a ProviderMirror serving the given provider cache directory, or the
+// configured provider cache if dir is empty.
+func NewProviderMirror(dir string) *ProviderMirror {
+	if dir == "" {
+		dir = GetBinaryPath()
+	}
+
+	return &ProviderMirror{Dir: dir}
+}
+
+type mirrorIndex struct {
+	Versions map[string]struct{} `json:"versions"`
+}
+
+type mirrorArchive struct {
+	URL    string   `json:"url"`
+	Hashes []string `json:"hashes,omitempty"`
+}
+
+type mirrorVersion struct {
+	Archives map[string]mirrorArchive `json:"archives"`
+}
+
+// ServeHTTP serves <hostname>/<namespace>/<type>/index.json, <hostname>/<namespace>/<type>/<version>.json
+// and the archives they reference at <hostname>/<namespace>/<type>/<version>/<os>_<arch>.zip.
+func (m *ProviderMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 4 && parts[3] == "index.json":
+		m.serveIndex(w, r, filepath.Join(m.Dir, parts[0], parts[1], parts[2]))
+	case len(parts) == 4 && strings.HasSuffix(parts[3], ".json"):
+		ver := strings.TrimSuffix(parts[3], ".json")
+		m.serveVersion(w, r, filepath.Join(m.Dir, parts[0], parts[1], parts[2], ver), ver)
+	case len(parts) == 5 && strings.HasSuffix(parts[4], ".zip"):
+		platform, err := ParsePlatformE(strings.TrimSuffix(parts[4], ".zip"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		m.serveArchive(w, r, filepath.Join(m.Dir, parts[0], parts[1], parts[2], parts[3], platform.String()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *ProviderMirror) serveIndex(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		serveFileError(w, r, err)
+		return
+	}
+
+	index := mirrorIndex{Versions: map[string]struct{}{}}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := version.NewVersion(entry.Name()); err != nil {
+			continue
+		}
+		if platforms, _ := mirrorPlatforms(filepath.Join(dir, entry.Name())); len(platforms) > 0 {
+			index.Versions[entry.Name()] = struct{}{}
+		}
+	}
+
+	if len(index.Versions) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveJSON(w, index)
+}
+
+func (m *ProviderMirror) serveVersion(w http.ResponseWriter, r *http.Request, dir, ver string) {
+	platforms, err := mirrorPlatforms(dir)
+	if err != nil {
+		serveFileError(w, r, err)
+		return
+	}
+	if len(platforms) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	doc := mirrorVersion{Archives: map[string]mirrorArchive{}}
+	for _, platform := range platforms {
+		h1, err := hashPackageV1(filepath.Join(dir, platform.String()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Archive URLs are relative to the URL of this document.
+		doc.Archives[platform.String()] = mirrorArchive{
+			URL:    ver + "/" + platform.String() + ".zip",
+			Hashes: []string{h1},
+		}
+	}
+
+	serveJSON(w, doc)
+}
+
+func (m *ProviderMirror) serveArchive(w http.ResponseWriter, r *http.Request, dir string) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		serveFileError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := writeZipE(w, dir); err != nil {
+		// The status has already been sent, so the best we can do is abort the response.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// mirrorPlatforms returns the platforms a provider version in the cache has packages for.
+func mirrorPlatforms(dir string) ([]Platform, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var platforms []Platform
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if platform, err := ParsePlatformE(entry.Name()); err == nil {
+			platforms = append(platforms, platform)
+		}
+	}
+
+	return platforms, nil
+}
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func serveFileError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writeZipE writes a zip archive of every file in dir to w, keeping their modes so that
+// binaries stay executable once Terraform unpacks them.
+func writeZipE(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ProviderMirrorServer is a ProviderMirror served over HTTPS, which Terraform requires of
+// network mirrors.
+type ProviderMirrorServer struct {
+	*httptest.Server
+
+	// Dir is the provider cache directory the mirror serves.
+	Dir string
+	// CertFile is a PEM file containing the server's certificate.
+	CertFile string
+	// CABundleFile is a PEM file containing the system's trusted certificate authorities
+	// followed by the server's certificate. Terraform and its providers trust the mirror, and
+	// every server they trusted before, when it is set as SSL_CERT_FILE.
+	CABundleFile string
+}
+
+// StartProviderMirror starts a provider network mirror serving the provider cache, which is shut
+// down when the test and all its subtests finish.
+//
+// Usage:
+// * dir is the provider cache directory to serve. Use "" for the configured provider cache.
+func StartProviderMirror(t *testing.T, dir string) *ProviderMirrorServer {
+	mirror := NewProviderMirror(dir)
+	server := httptest.NewTLSServer(mirror)
+	t.Cleanup(server.Close)
+
+	tmpDir := t.TempDir()
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	certFile := filepath.Join(tmpDir, "provider-mirror.pem")
+	if err := os.WriteFile(certFile, cert, 0o644); err != nil {
+		t.Fatalf("error when attempting to write the provider mirror certificate: %s", err)
+	}
+
+	bundle, err := systemCABundleE()
+	if err != nil {
+		t.Fatalf("error when attempting to read the system's trusted certificate authorities: %s", err)
+	}
+	if len(bundle) > 0 && !bytes.HasSuffix(bundle, []byte("\n")) {
+		bundle = append(bundle, '\n')
+	}
+
+	bundleFile := filepath.Join(tmpDir, "ca-bundle.pem")
+	if err := os.WriteFile(bundleFile, append(bundle, cert...), 0o644); err != nil {
+		t.Fatalf("error when attempting to write the provider mirror certificate bundle: %s", err)
+	}
+
+	return &ProviderMirrorServer{Server: server, Dir: mirror.Dir, CertFile: certFile, CABundleFile: bundleFile}
+}
+
+// CLIConfig returns a CLIConfig that installs every provider from the mirror.
+func (s *ProviderMirrorServer) CLIConfig() CLIConfig {
+	return CLIConfig{
+		NetworkMirrors: []ProviderInstallationMethod{{Location: s.URL + "/"}},
+	}
+}
+
+// EnvVars returns the environment variables that make Terraform install providers from the
+// mirror: the CLI configuration written to filename and the CA bundle trusting the mirror as
+// well as the system's certificate authorities, so that providers can still reach their APIs.
+//
+// Go ignores SSL_CERT_FILE on macOS, where the mirror's certificate cannot be trusted without
+// adding it to the keychain. There, the CLI configuration installs providers from the mirror's
+// directory as a filesystem mirror instead, which holds the same providers.
+//
+// Usage:
+// * filename is the file to write the CLI configuration to.
+// * cfg is the configuration to extend with the mirror, such as one with a PluginCacheDir.
+// * sourceAddresses are the providers to install from the mirror, with every other provider
+// installed directly from its registry. Use nil to install every provider from the mirror.
+func (s *ProviderMirrorServer) EnvVars(t *testing.T, filename string, cfg CLIConfig, sourceAddresses []string) map[string]string {
+	cfg.FilesystemMirrors = nil
+	cfg.NetworkMirrors = nil
+	cfg.Direct = nil
+
+	mirror := ProviderInstallationMethod{Location: s.URL + "/"}
+	if runtime.GOOS == "darwin" {
+		mirror.Location = s.Dir
+	}
+	if sourceAddresses != nil {
+		mirror.Include = providerSourcePatterns(sourceAddresses)
+		cfg.Direct = &ProviderInstallationMethod{Exclude: mirror.Include}
+	}
+
+	envVars := map[string]string{CLIConfigEnvVar: filename}
+	switch {
+	case sourceAddresses != nil && len(mirror.Include) == 0:
+		// Nothing is installed from the mirror, as a method without include patterns would be
+		// used for every provider.
+	case runtime.GOOS == "darwin":
+		cfg.FilesystemMirrors = []ProviderInstallationMethod{mirror}
+	default:
+		cfg.NetworkMirrors = []ProviderInstallationMethod{mirror}
+		envVars[SSLCertFileEnvVar] = s.CABundleFile
+	}
+	WriteCLIConfig(t, filename, cfg)
+
+	return envVars
+}
+
+// systemCABundleE returns the PEM encoded certificate authorities the system trusts: those in
+// SSL_CERT_FILE when it is set, or the first of the system's CA bundles found otherwise. It is
+// empty if there is none.
+func systemCABundleE() ([]byte, error) {
+	files := systemCABundleFiles
+	if f := os.Getenv(SSLCertFileEnvVar); f != "" {
+		files = []string{f}
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return content, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,124 @@
+package testhelpers
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProviderMirrorCABundle(t *testing.T) {
+	system := filepath.Join(t.TempDir(), "system.pem")
+	other := StartProviderMirror(t, t.TempDir())
+	systemCert, err := os.ReadFile(other.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(system, systemCert, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SSLCertFileEnvVar, system)
+
+	mirror := StartProviderMirror(t, t.TempDir())
+
+	bundle, err := os.ReadFile(mirror.CABundleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certs []*x509.Certificate
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) != 2 {
+		t.Fatalf("expected the system's certificate and the mirror's, got %d certificates", len(certs))
+	}
+	if !bytes.Equal(certs[0].Raw, other.Certificate().Raw) {
+		t.Error("expected the bundle to start with the system's certificates")
+	}
+	if !bytes.Equal(certs[1].Raw, mirror.Certificate().Raw) {
+		t.Error("expected the bundle to end with the mirror's certificate")
+	}
+
+	envVars := mirror.EnvVars(t, filepath.Join(t.TempDir(), "terraform.rc"), CLIConfig{}, nil)
+	cfg, err := os.ReadFile(envVars[CLIConfigEnvVar])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runtime.GOOS == "darwin" {
+		if !strings.Contains(string(cfg), "filesystem_mirror") {
+			t.Errorf("expected a filesystem mirror on macOS, got:\n%s", cfg)
+		}
+		return
+	}
+
+	if envVars[SSLCertFileEnvVar] != mirror.CABundleFile {
+		t.Errorf("expected %s to be %s, got %s", SSLCertFileEnvVar, mirror.CABundleFile, envVars[SSLCertFileEnvVar])
+	}
+	if !strings.Contains(string(cfg), mirror.URL) {
+		t.Errorf("expected the CLI configuration to use the network mirror, got:\n%s", cfg)
+	}
+}
+
+func TestProviderMirrorEnvVarsInclude(t *testing.T) {
+	mirror := StartProviderMirror(t, t.TempDir())
+
+	tests := []struct {
+		name            string
+		sourceAddresses []string
+		want            []string
+		notWant         []string
+	}{
+		{
+			name:    "every provider",
+			notWant: []string{"include", "direct"},
+		},
+		{
+			name:            "tested providers",
+			sourceAddresses: []string{"hashicorp/aws"},
+			want:            []string{`include = ["registry.terraform.io/hashicorp/aws"]`, "direct", `exclude = ["registry.terraform.io/hashicorp/aws"]`},
+		},
+		{
+			name:            "no tested providers",
+			sourceAddresses: []string{},
+			want:            []string{"direct"},
+			notWant:         []string{"_mirror"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envVars := mirror.EnvVars(t, filepath.Join(t.TempDir(), "terraform.rc"), CLIConfig{}, tt.sourceAddresses)
+			cfg, err := os.ReadFile(envVars[CLIConfigEnvVar])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(string(cfg), want) {
+					t.Errorf("expected the CLI configuration to contain %q, got:\n%s", want, cfg)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(cfg), notWant) {
+					t.Errorf("expected the CLI configuration not to contain %q, got:\n%s", notWant, cfg)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// DefaultRegistryHostname is the hostname of the registry providers are installed from when
+// their source address doesn't include one.
+const DefaultRegistryHostname = "registry.terraform.io"
+
+var (
+	providerRegistriesMx sync.RWMutex
+	providerRegistries   = map[string]ReleaseSource{}
+)
+
+// SetProviderRegistry changes the ReleaseSource used to discover and download the providers
+// whose source address has the given registry hostname. Providers are named by their
+// "namespace/type" address when passed to the ReleaseSource.
+func SetProviderRegistry(hostname string, src ReleaseSource) {
+	providerRegistriesMx.Lock()
+	defer providerRegistriesMx.Unlock()
+
+	providerRegistries[strings.ToLower(hostname)] = src
+}
+
+// SetProviderRegistryURL points the registry hostname at a registry served from a different
+// base URL, such as a local stand-in started with httptest.
+func SetProviderRegistryURL(hostname, baseURL string) {
+	SetProviderRegistry(hostname, NewRegistryReleaseSource(baseURL))
+}
+
+// GetProviderRegistry returns the ReleaseSource used for providers from the given registry
+// hostname, which is the registry served from https://<hostname> unless it has been changed
+// with SetProviderRegistry.
+func GetProviderRegistry(hostname string) ReleaseSource {
+	hostname = strings.ToLower(hostname)
+
+	providerRegistriesMx.RLock()
+	src, ok := providerRegistries[hostname]
+	providerRegistriesMx.RUnlock()
+	if ok {
+		return src
+	}
+
+	providerRegistriesMx.Lock()
+	defer providerRegistriesMx.Unlock()
+
+	if src, ok := providerRegistries[hostname]; ok {
+		return src
+	}
+
+	src = NewRegistryReleaseSource("https://" + hostname)
+	providerRegistries[hostname] = src
+	return src
+}
+
+// ParseProviderSourceE splits a provider source address, as found by GetSourceAddressE, into
+// its registry hostname, namespace and type, or returns an error if it is not a valid address.
+// The hostname defaults to registry.terraform.io and the namespace to hashicorp.
+func ParseProviderSourceE(sourceAddress string) (hostname, namespace, providerType string, err error) {
+	parts := strings.Split(sourceAddress, "/")
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", fmt.Errorf("invalid provider source address: %q", sourceAddress)
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		return DefaultRegistryHostname, "hashicorp", strings.ToLower(parts[0]), nil
+	case 2:
+		return DefaultRegistryHostname, strings.ToLower(parts[0]), strings.ToLower(parts[1]), nil
+	case 3:
+		return strings.ToLower(parts[0]), strings.ToLower(parts[1]), strings.ToLower(parts[2]), nil
+	default:
+		return "", "", "", fmt.Errorf("invalid provider source address: %q", sourceAddress)
+	}
+}
+
+// GetAvailableProviderVersionsE returns every version of the provider published to the
+// registry its source address belongs to in ascending semver order, or returns an error if
+// something goes wrong.
+func GetAvailableProviderVersionsE(sourceAddress string) ([]string, error) {
+	return GetAvailableProviderVersionsContextE(context.Background(), sourceAddress)
+}
+
+// GetAvailableProviderVersionsContextE returns every version of the provider published to the
+// registry its source address belongs to in ascending semver order, stopping when ctx is done.
+// Listings are cached on disk for the version cache TTL, see SetVersionCacheTTL.
+//
+// Usage:
+// * ctx is the context of the requests.
+// * sourceAddress is the sourceAddress of the provider.
+func GetAvailableProviderVersionsContextE(ctx context.Context, sourceAddress string) ([]string, error) {
+	hostname, namespace, providerType, err := ParseProviderSourceE(sourceAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return listVersionsE(ctx, GetProviderRegistry(hostname), namespace+"/"+providerType)
+}
+
+// GetAvailableProviderVersions returns every version of the provider published to the
+// registry its source address belongs to, or fails the test if something goes wrong.
+func GetAvailableProviderVersions(t *testing.T, sourceAddress string) []string {
+	out, err := GetAvailableProviderVersionsContextE(testContext(t), sourceAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// GetProviderBuildE returns the build of the provider version for the given os and
+// architecture from the registry its source address belongs to, or returns an error if
+// something goes wrong.
+func GetProviderBuildE(sourceAddress, version, goos, goarch string) (*Build, error) {
+	return GetProviderBuildContextE(context.Background(), sourceAddress, version, goos, goarch)
+}
+
+// GetProviderBuildContextE returns the build of the provider version for the given os and
+// architecture from the registry its source address belongs to, stopping when ctx is done, or
+// returns an error if something goes wrong.
+func GetProviderBuildContextE(ctx context.Context, sourceAddress, version, goos, goarch string) (*Build, error) {
+	hostname, namespace, providerType, err := ParseProviderSourceE(sourceAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetProviderRegistry(hostname).GetBuild(ctx, namespace+"/"+providerType, version, goos, goarch)
+}
@@ -1,82 +1,26 @@
 package testhelpers
 
 import (
-	"archive/zip"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"path/filepath"
-	"regexp"
 	"runtime"
-	"strings"
 	"testing"
-
-	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclwrite"
 )
 
-// GetProviderConstraintE returns the version string for the given provider
-// or an error if the provider cannot be found
+// GetProviderConstraintE returns the version string for the given provider, combining the
+// constraints declared for it across the module's files, or an error if the provider cannot
+// be found or has no version constraint
 func GetProviderConstraintE(srcDir, provider string) (string, error) {
-	files, err := os.ReadDir(srcDir)
+	p, err := GetRequiredProviderE(srcDir, provider)
 	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
+		return "", err
 	}
 
-	vRegexp := regexp.MustCompile("version\\s*=\\s*\"([^\"]+)\"")
-
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		if !strings.HasSuffix(file.Name(), ".tf") {
-			continue
-		}
-
-		filename := fmt.Sprintf("%s/%s", srcDir, file.Name())
-		content, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return "", fmt.Errorf("Error: %s", err)
-		}
-
-		f, diag := hclwrite.ParseConfig(content, file.Name(), hcl.Pos{Line: 1, Column: 1})
-		if diag.HasErrors() {
-			return "", errors.New(diag.Error())
-		}
-
-		for _, block := range f.Body().Blocks() {
-			if block.Type() != "terraform" {
-				continue
-			}
-
-			for _, block := range block.Body().Blocks() {
-				if block.Type() != "required_providers" {
-					continue
-				}
-
-				provMap := block.Body().GetAttribute(provider)
-				if provMap == nil {
-					continue
-				}
-
-				val := provMap.BuildTokens(nil).Bytes()
-				constraint := vRegexp.FindSubmatch(val)
-
-				if constraint == nil || len(constraint) < 2 {
-					continue
-				}
-
-				return string(constraint[1]), nil
-			}
-		}
+	if len(p.VersionConstraints) == 0 {
+		return "", fmt.Errorf("provider %s has no version constraint", provider)
 	}
 
-	return "", fmt.Errorf("provider %s not found", provider)
+	return p.VersionConstraint(), nil
 }
 
 // GetProviderConstraint returns the version string for the given provider or
@@ -84,167 +28,75 @@ func GetProviderConstraintE(srcDir, provider string) (string, error) {
 func GetProviderConstraint(t *testing.T, srcDir, provider string) string {
 	constraint, err := GetProviderConstraintE(srcDir, provider)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return constraint
 }
 
 // GetBinaryUrlE will return the correct download URL for the provider binary version requested
-// based on the operating system and architecture by fetching it from the Hashicorp releases API
+// based on the operating system and architecture by fetching it from the configured ReleaseSource
 //
 // Usage:
 // * version is the version of provider to download.
 func GetBinaryUrl(version string, providerName string) (string, error) {
-	var binaryUrl string
-	operatingSystem := runtime.GOOS
-	architecture := runtime.GOARCH
-	releasesApiReq := fmt.Sprintf("https://api.releases.hashicorp.com/v1/releases/terraform-provider-"+providerName+"/%s", version)
-	resp, err := http.Get(releasesApiReq)
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-	body, err := io.ReadAll(resp.Body)
-	_ = resp.Body.Close()
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	var result struct {
-		Builds []struct {
-			Arch string `json:"arch"`
-			Os   string `json:"os"`
-			Url  string `json:"url"`
-		}
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
-	}
+	return GetBinaryUrlContext(context.Background(), version, providerName)
+}
 
-	for _, res := range result.Builds {
-		if res.Arch == architecture && res.Os == operatingSystem {
-			binaryUrl = res.Url
-		}
+// GetBinaryUrlContext will return the download URL for the provider binary version requested for
+// the underlying operating system and architecture, stopping when ctx is done
+//
+// Usage:
+// * ctx is the context of the request.
+// * version is the version of provider to download.
+func GetBinaryUrlContext(ctx context.Context, version string, providerName string) (string, error) {
+	build, err := GetReleaseSource().GetBuild(ctx, "terraform-provider-"+providerName, version, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find an appropriate binary download URL for the underlying OS and architecture: %w", err)
 	}
 
-	if len(binaryUrl) > 0 {
-		return binaryUrl, nil
-	} else {
-		return "", errors.New("Unable to find an appropriate binary download URL for the underlying OS and architecture")
-	}
+	return build.URL, nil
 }
 
-// GetBinaryPath will return the cache path required to store the provider cache
+// GetBinaryPath will return the cache path required to store the provider cache, which is
+// ~/.terraform.d/plugin-cache unless the Cache has been configured otherwise
 //
 // Usage:
 // * cachePath is the path required to store the provider cache
 func GetBinaryPath() (cachePath string) {
-	homeDirectory, _ := os.UserHomeDir()
-	binaryPath := filepath.Join(homeDirectory, ".terraform.d/plugin-cache")
-	return binaryPath
+	return GetCache().ProviderDir
 }
 
 // DownloadProviderVersionE will download the specified version of the provider into the ~/.terraform.d/plugin-cache directory
+// from the registry its source address belongs to, verifying it against the checksums published for the release.
+// It is safe to call from parallel tests.
+//
+// It returns the cache directory of the provider version, which holds a directory for each
+// platform: <cache>/<hostname>/<namespace>/<type>/<version>. It returned the plugin cache
+// directory after a fresh download before.
+//
+// Deprecated: providerName is ignored, as the provider is found from sourceAddress. Use
+// DownloadProviderVersionForPlatformsE instead.
+//
 // Usage:
 // * version is the version of provider to download.
 // * sourceAddress is the sourceAddress of provider to download.
-// * providerName is the name of provider to download.
+// * providerName is ignored.
 func DownloadProviderVersionE(version string, sourceAddress string, providerName string) (binaryPath string, err error) {
-	operatingSystem := runtime.GOOS
-	architecture := runtime.GOARCH
-	// Initialise all path variables
-	binaryDownloadDirectory := GetBinaryPath()
-	binaryPath = binaryDownloadDirectory + "/registry.terraform.io/" + sourceAddress + "/" + version
-	// Don't do anything if the required binary already exists
-	_, err = os.Stat(binaryPath)
-	if err == nil {
-		return binaryPath, nil
-	}
-	if !errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("unexpected error: %w", err)
-	}
-	// Create ~/.terraform.d/plugin-cache directory if it doesn't exist
-	// https://gist.github.com/ivanzoid/5040166bb3f0c82575b52c2ca5f5a60c
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		os.MkdirAll(binaryPath, os.ModeDir|0o755)
-	}
-	var binaryUrl string
-	binaryUrl, err = GetBinaryUrl(version, providerName)
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	req := fmt.Sprintf(binaryUrl)
-
-	resp, err := http.Get(req)
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return "", nil
-	}
-
-	// Create the file
-	out, err := os.Create("/tmp/" + version + "_binary.zip")
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	// Sample code to extract zip file taken from https://stackoverflow.com/questions/20357223/easy-way-to-unzip-file-with-golang
-	r, err := zip.OpenReader(out.Name())
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-
-	// Cleanup temp directories
-	defer func() {
-		if tempErr := r.Close(); tempErr != nil {
-			err = tempErr
-		}
-	}()
-	defer os.Remove(out.Name())
-	zipExtractPath := binaryDownloadDirectory + "/bin_" + version
-	os.MkdirAll(zipExtractPath, 0o755)
-
-	// Cleanup zip files
-	defer os.RemoveAll(zipExtractPath)
-	for _, f := range r.File {
-		err := extractAndWriteFile(zipExtractPath, f)
-		if err != nil {
-			return "", fmt.Errorf("Error: %s", err)
-		}
-	}
-
-	// Move file from temporarily extracted location
-	oldBinaryLocation := zipExtractPath + "/"
-	err = os.Rename(oldBinaryLocation, binaryPath+"/"+operatingSystem+"_"+architecture)
-	if err != nil {
-		return "", fmt.Errorf("Error: %s", err)
-	}
-	return binaryDownloadDirectory, nil
+	return DownloadProviderVersionForPlatformsE(version, sourceAddress, []Platform{CurrentPlatform()})
 }
 
 // DownloadProviderVersion will download the specified version of provider into the ~/.terraform.d/plugin-cache directory.
+// It returns the cache directory of the provider version, like DownloadProviderVersionE.
+//
+// Deprecated: providerName is ignored, as the provider is found from sourceAddress. Use
+// DownloadProviderVersionForPlatforms instead.
 //
 // Usage:
 // * version is the version of provider to download.
 // * sourceAddress is the sourceAddress of provider to download.
-// * providerName is the name of provider to download.
+// * providerName is ignored.
 func DownloadProviderVersion(t *testing.T, version string, sourceAddress string, providerName string) string {
-	binaryPath, err := DownloadProviderVersionE(version, sourceAddress, providerName)
-	if err != nil {
-		t.Fatalf(err.Error())
-	}
-
-	return binaryPath
+	return DownloadProviderVersionForPlatforms(t, version, sourceAddress, []Platform{CurrentPlatform()})
 }
 
 // DownloadRequiredProviders will download the specified version of provider into the ~/.terraform.d/plugin-cache directory.
@@ -253,12 +105,12 @@ func DownloadProviderVersion(t *testing.T, version string, sourceAddress string,
 // * provider is the name of provider to download.
 func DownloadRequiredProviders(t *testing.T, srcDir string, provider string) {
 	constraint := GetProviderConstraint(t, srcDir, provider)
-	available := GetAvailableVersions(t, "terraform-provider-"+provider)
-	testVers := GetMatchingVersions(t, constraint, available)
 	sourceAddress := GetSourceAddress(t, srcDir, provider)
+	available := GetAvailableProviderVersions(t, sourceAddress)
+	testVers := GetMatchingVersions(t, constraint, available)
 	for _, version := range testVers {
 		version := version
-		DownloadProviderVersion(t, version, sourceAddress, provider)
+		DownloadProviderVersionForPlatforms(t, version, sourceAddress, []Platform{CurrentPlatform()})
 	}
 }
 
@@ -269,7 +121,7 @@ func DownloadRequiredProviders(t *testing.T, srcDir string, provider string) {
 func GetSourceAddress(t *testing.T, srcDir, provider string) string {
 	constraint, err := GetSourceAddressE(srcDir, provider, "source")
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return constraint
 }
@@ -277,62 +129,58 @@ func GetSourceAddress(t *testing.T, srcDir, provider string) string {
 // GetSourceAddressE returns the source string for the given provider
 // or an error if the provider cannot be found
 // Usage:
-// * attrribute is the name of attrribute to return.
+// * attrribute is the name of attrribute to return, either "source" or "version".
 func GetSourceAddressE(srcDir, provider string, attrribute string) (string, error) {
-	files, err := os.ReadDir(srcDir)
+	p, err := GetRequiredProviderE(srcDir, provider)
 	if err != nil {
-		fmt.Errorf("Error: %s", err)
 		return "", err
 	}
 
-	vRegexp := regexp.MustCompile(attrribute + "\\s*=\\s*\"([^\"]+)\"")
-
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		if !strings.HasSuffix(file.Name(), ".tf") {
-			continue
-		}
-
-		filename := fmt.Sprintf("%s/%s", srcDir, file.Name())
-		content, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return "", fmt.Errorf("Error: %s", err)
+	switch attrribute {
+	case "source":
+		if p.Source == "" {
+			return "", fmt.Errorf("provider %s has no source address", provider)
 		}
-
-		f, diag := hclwrite.ParseConfig(content, file.Name(), hcl.Pos{Line: 1, Column: 1})
-		if diag.HasErrors() {
-			return "", errors.New(diag.Error())
+		return p.Source, nil
+	case "version":
+		if len(p.VersionConstraints) == 0 {
+			return "", fmt.Errorf("provider %s has no version constraint", provider)
 		}
+		return p.VersionConstraint(), nil
+	default:
+		return "", fmt.Errorf("unsupported required_providers attribute %q", attrribute)
+	}
+}
 
-		for _, block := range f.Body().Blocks() {
-			if block.Type() != "terraform" {
-				continue
-			}
-
-			for _, block := range block.Body().Blocks() {
-				if block.Type() != "required_providers" {
-					continue
-				}
-
-				provMap := block.Body().GetAttribute(provider)
-				if provMap == nil {
-					continue
-				}
+// GetRequiredProviderNamesE returns the local names of every provider declared in the
+// required_providers blocks of the module, in alphabetical order, or an error if the module
+// cannot be read.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+func GetRequiredProviderNamesE(srcDir string) ([]string, error) {
+	providers, err := GetRequiredProvidersE(srcDir)
+	if err != nil {
+		return nil, err
+	}
 
-				val := provMap.BuildTokens(nil).Bytes()
-				constraint := vRegexp.FindSubmatch(val)
+	names := make([]string, 0, len(providers))
+	for _, p := range providers {
+		names = append(names, p.Name)
+	}
 
-				if constraint == nil || len(constraint) < 2 {
-					continue
-				}
+	return names, nil
+}
 
-				return string(constraint[1]), nil
-			}
-		}
+// GetRequiredProviderNames returns the local names of every provider the module requires, or
+// fails the test if the module cannot be read.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+func GetRequiredProviderNames(t *testing.T, srcDir string) []string {
+	names, err := GetRequiredProviderNamesE(srcDir)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	return "", fmt.Errorf("provider %s not found", provider)
+	return names
 }
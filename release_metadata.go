@@ -0,0 +1,153 @@
+package testhelpers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// ReleaseMetadata describes a single released version.
+type ReleaseMetadata struct {
+	Version string `json:"version"`
+	// CreatedAt is when the version was released. It is the zero value when the release source
+	// doesn't publish release dates, such as a provider registry.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// Prerelease is true for alpha, beta and rc releases.
+	Prerelease bool `json:"prerelease,omitempty"`
+	// ChangelogURL links to the changes in the version, where the release source publishes one.
+	ChangelogURL string `json:"changelog_url,omitempty"`
+}
+
+// ReleaseMetadataSource is implemented by the release sources that publish metadata about each
+// release, such as the HashiCorp releases API. Only the versions of releases from other
+// sources are known.
+type ReleaseMetadataSource interface {
+	// ListReleases returns the metadata of every published version of the release.
+	ListReleases(ctx context.Context, release string) ([]ReleaseMetadata, error)
+}
+
+// GetAvailableReleasesE returns the metadata of every available version of the given provider
+// or the Terraform binary in ascending semver order, or returns an error if something goes
+// wrong. Prereleases are left out unless the PrereleasePolicy includes them.
+func GetAvailableReleasesE(release string) ([]ReleaseMetadata, error) {
+	return GetAvailableReleasesContextE(context.Background(), release)
+}
+
+// GetAvailableReleasesContextE returns the metadata of every available version of the given
+// provider or the Terraform binary in ascending semver order, stopping when ctx is done, or
+// returns an error if something goes wrong.
+func GetAvailableReleasesContextE(ctx context.Context, release string) ([]ReleaseMetadata, error) {
+	return listReleasesE(ctx, GetReleaseSource(), release)
+}
+
+// GetAvailableReleases returns the metadata of every available version of the given provider or
+// the Terraform binary in ascending semver order, or fails the test if something goes wrong.
+func GetAvailableReleases(t *testing.T, release string) []ReleaseMetadata {
+	releases, err := GetAvailableReleasesContextE(testContext(t), release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return releases
+}
+
+// GetAvailableEngineReleasesE returns the metadata of every released version of the engine in
+// ascending semver order, or returns an error if something goes wrong.
+func GetAvailableEngineReleasesE(engine Engine) ([]ReleaseMetadata, error) {
+	return GetAvailableEngineReleasesContextE(context.Background(), engine)
+}
+
+// GetAvailableEngineReleasesContextE returns the metadata of every released version of the
+// engine in ascending semver order, stopping when ctx is done, or returns an error if
+// something goes wrong.
+func GetAvailableEngineReleasesContextE(ctx context.Context, engine Engine) ([]ReleaseMetadata, error) {
+	return listReleasesE(ctx, engine.GetReleaseSource(), engine.Release)
+}
+
+// GetAvailableEngineReleases returns the metadata of every released version of the engine in
+// ascending semver order, or fails the test if something goes wrong.
+func GetAvailableEngineReleases(t *testing.T, engine Engine) []ReleaseMetadata {
+	releases, err := GetAvailableEngineReleasesContextE(testContext(t), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return releases
+}
+
+// listSourceReleasesE lists the releases from the source, with only their versions known when
+// the source doesn't publish any metadata.
+func listSourceReleasesE(ctx context.Context, src ReleaseSource, release string) ([]ReleaseMetadata, error) {
+	if metadataSource, ok := src.(ReleaseMetadataSource); ok {
+		return metadataSource.ListReleases(ctx, release)
+	}
+
+	versions, err := src.ListVersions(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+	return releasesFromVersions(versions), nil
+}
+
+// releasesFromVersions returns the metadata known from the versions alone.
+func releasesFromVersions(versions []string) []ReleaseMetadata {
+	releases := make([]ReleaseMetadata, 0, len(versions))
+	for _, ver := range versions {
+		releases = append(releases, newReleaseMetadata(ver))
+	}
+	return releases
+}
+
+// newReleaseMetadata returns the metadata known from the version alone.
+func newReleaseMetadata(ver string) ReleaseMetadata {
+	release := ReleaseMetadata{Version: ver}
+	if v, err := version.NewVersion(ver); err == nil {
+		release.Prerelease = v.Prerelease() != ""
+	}
+	return release
+}
+
+// releaseVersions returns the versions of the releases, in the same order.
+func releaseVersions(releases []ReleaseMetadata) []string {
+	versions := make([]string, 0, len(releases))
+	for _, release := range releases {
+		versions = append(versions, release.Version)
+	}
+	return versions
+}
+
+// releasesByVersion indexes the releases by their normalised version, so that they can be
+// found from the versions GetMatchingVersionsE returns.
+func releasesByVersion(releases []ReleaseMetadata) map[string]ReleaseMetadata {
+	index := make(map[string]ReleaseMetadata, len(releases))
+	for _, release := range releases {
+		index[normaliseVersion(release.Version)] = release
+	}
+	return index
+}
+
+// releasesForVersions returns the metadata of each of the versions from the index, or the
+// metadata known from the version alone when it isn't in the index.
+func releasesForVersions(versions []string, index map[string]ReleaseMetadata) []ReleaseMetadata {
+	releases := make([]ReleaseMetadata, 0, len(versions))
+	for _, ver := range versions {
+		release, ok := index[normaliseVersion(ver)]
+		if !ok {
+			release = newReleaseMetadata(ver)
+		}
+		release.Version = ver
+		releases = append(releases, release)
+	}
+	return releases
+}
+
+// normaliseVersion returns the canonical form of the version, or the version itself if it
+// cannot be parsed. Versions are matched, sorted, selected, pinned and blocked in this form, so
+// that they compare equal however their release source or the module spells them.
+func normaliseVersion(ver string) string {
+	v, err := version.NewVersion(ver)
+	if err != nil {
+		return ver
+	}
+	return v.String()
+}
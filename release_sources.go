@@ -0,0 +1,453 @@
+package testhelpers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Build describes the downloadable archive of a single release version for one operating
+// system and architecture.
+type Build struct {
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+
+	// SHASum is the hex encoded SHA256 checksum of the archive.
+	SHASum string `json:"shasum,omitempty"`
+	// SHASumsURL is the URL of the SHA256SUMS file covering every build of the version.
+	SHASumsURL string `json:"shasums_url,omitempty"`
+	// SHASumsSignatureURL is the URL of the detached signature of the SHA256SUMS file.
+	SHASumsSignatureURL string `json:"shasums_signature_url,omitempty"`
+	// SigningKeys are ASCII armored public keys the publisher signs its releases with.
+	SigningKeys []string `json:"signing_keys,omitempty"`
+
+	// shaSums is the content of the SHA256SUMS file, when the release source fetched it to
+	// resolve the build.
+	shaSums []byte
+}
+
+// ReleaseSource lists and resolves the released versions of Terraform and its providers.
+// Releases are named the way the HashiCorp releases API names them, such as "terraform" or
+// "terraform-provider-aws".
+type ReleaseSource interface {
+	// ListVersions returns every published version of the release.
+	ListVersions(ctx context.Context, release string) ([]string, error)
+	// GetBuild returns the build of the release version for the given os and architecture.
+	GetBuild(ctx context.Context, release, version, goos, goarch string) (*Build, error)
+}
+
+var (
+	releaseSourceMx      sync.RWMutex
+	defaultReleaseSource ReleaseSource = NewHashicorpReleaseSource()
+)
+
+// SetReleaseSource changes the ReleaseSource used by the package level functions, such as
+// GetAvailableVersionsE and DownloadTerraformVersionE.
+func SetReleaseSource(src ReleaseSource) {
+	releaseSourceMx.Lock()
+	defer releaseSourceMx.Unlock()
+
+	defaultReleaseSource = src
+}
+
+// GetReleaseSource returns the ReleaseSource used by the package level functions.
+func GetReleaseSource() ReleaseSource {
+	releaseSourceMx.RLock()
+	defer releaseSourceMx.RUnlock()
+
+	return defaultReleaseSource
+}
+
+// HashicorpReleaseSource is a ReleaseSource backed by the Hashicorp releases API.
+type HashicorpReleaseSource struct {
+	// BaseURL is the root of the releases API, https://api.releases.hashicorp.com by default.
+	BaseURL string
+}
+
+// NewHashicorpReleaseSource returns a ReleaseSource for https://api.releases.hashicorp.com.
+func NewHashicorpReleaseSource() *HashicorpReleaseSource {
+	return &HashicorpReleaseSource{BaseURL: "https://api.releases.hashicorp.com"}
+}
+
+// ListVersions returns every version of the release, paging through the releases API.
+func (s *HashicorpReleaseSource) ListVersions(ctx context.Context, release string) ([]string, error) {
+	releases, err := s.ListReleases(ctx, release)
+	if err != nil {
+		return nil, err
+	}
+
+	return releaseVersions(releases), nil
+}
+
+// ListReleases returns the metadata of every version of the release, paging through the
+// releases API.
+func (s *HashicorpReleaseSource) ListReleases(ctx context.Context, release string) ([]ReleaseMetadata, error) {
+	var releases []ReleaseMetadata
+
+	req := fmt.Sprintf("%s/v1/releases/%s?limit=20", s.BaseURL, release)
+
+	for {
+		var result []struct {
+			Version      string `json:"version"`
+			CreatedAt    string `json:"timestamp_created"`
+			IsPrerelease bool   `json:"is_prerelease"`
+			ChangelogURL string `json:"url_changelog"`
+		}
+
+		if err := getJSON(ctx, req, &result); err != nil {
+			return nil, err
+		}
+
+		if len(result) == 0 {
+			break
+		}
+
+		for _, res := range result {
+			createdAt, err := time.Parse(time.RFC3339, res.CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid release timestamp %q for %s %s: %w", res.CreatedAt, release, res.Version, err)
+			}
+
+			releases = append(releases, ReleaseMetadata{
+				Version:      res.Version,
+				CreatedAt:    createdAt,
+				Prerelease:   res.IsPrerelease,
+				ChangelogURL: res.ChangelogURL,
+			})
+			req = fmt.Sprintf("%s/v1/releases/%s?limit=20&after=%s", s.BaseURL, release, url.QueryEscape(res.CreatedAt))
+		}
+	}
+
+	return releases, nil
+}
+
+// GetBuild returns the build of the release version for the os and architecture, including its
+// checksum from the release's SHA256SUMS file.
+func (s *HashicorpReleaseSource) GetBuild(ctx context.Context, release, version, goos, goarch string) (*Build, error) {
+	var result struct {
+		Builds []struct {
+			Arch string `json:"arch"`
+			Os   string `json:"os"`
+			Url  string `json:"url"`
+		} `json:"builds"`
+		SHASumsURL           string   `json:"url_shasums"`
+		SHASumsSignatureURLs []string `json:"url_shasums_signatures"`
+	}
+
+	if err := getJSON(ctx, fmt.Sprintf("%s/v1/releases/%s/%s", s.BaseURL, release, version), &result); err != nil {
+		return nil, err
+	}
+
+	for _, res := range result.Builds {
+		if res.Arch != goarch || res.Os != goos {
+			continue
+		}
+
+		build := &Build{
+			OS:         res.Os,
+			Arch:       res.Arch,
+			Filename:   path.Base(res.Url),
+			URL:        res.Url,
+			SHASumsURL: result.SHASumsURL,
+		}
+		if len(result.SHASumsSignatureURLs) > 0 {
+			build.SHASumsSignatureURL = result.SHASumsSignatureURLs[0]
+		}
+
+		if build.SHASumsURL != "" {
+			if err := build.fetchSHA256SumsE(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		return build, nil
+	}
+
+	return nil, fmt.Errorf("no %s %s build found for %s_%s", release, version, goos, goarch)
+}
+
+// RegistryReleaseSource is a ReleaseSource backed by a registry implementing the Terraform
+// provider registry protocol. It can only resolve providers, which are named either by their
+// release name, such as "terraform-provider-aws" for the hashicorp namespace, or by their
+// "namespace/type" source address.
+type RegistryReleaseSource struct {
+	// BaseURL is the root of the registry, such as https://registry.terraform.io.
+	BaseURL string
+
+	mx           sync.Mutex
+	providersURL *url.URL
+}
+
+// NewRegistryReleaseSource returns a ReleaseSource for the registry at the given base URL.
+func NewRegistryReleaseSource(baseURL string) *RegistryReleaseSource {
+	return &RegistryReleaseSource{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// ListVersions returns every version of the provider published to the registry.
+func (s *RegistryReleaseSource) ListVersions(ctx context.Context, release string) ([]string, error) {
+	namespace, providerType, err := parseRegistryRelease(release)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.providerEndpoint(ctx, namespace, providerType, "versions")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+
+	if err := getJSON(ctx, endpoint, &result); err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(result.Versions))
+	for _, res := range result.Versions {
+		versions = append(versions, res.Version)
+	}
+
+	return versions, nil
+}
+
+// GetBuild returns the build of the provider version for the os and architecture, as described by
+// the registry's download endpoint.
+func (s *RegistryReleaseSource) GetBuild(ctx context.Context, release, version, goos, goarch string) (*Build, error) {
+	namespace, providerType, err := parseRegistryRelease(release)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.providerEndpoint(ctx, namespace, providerType, version, "download", goos, goarch)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		OS                  string `json:"os"`
+		Arch                string `json:"arch"`
+		Filename            string `json:"filename"`
+		DownloadURL         string `json:"download_url"`
+		SHASumsURL          string `json:"shasums_url"`
+		SHASumsSignatureURL string `json:"shasums_signature_url"`
+		SHASum              string `json:"shasum"`
+		SigningKeys         struct {
+			GPGPublicKeys []struct {
+				ASCIIArmor string `json:"ascii_armor"`
+			} `json:"gpg_public_keys"`
+		} `json:"signing_keys"`
+	}
+
+	if err := getJSON(ctx, endpoint, &result); err != nil {
+		return nil, err
+	}
+
+	build := &Build{
+		OS:                  result.OS,
+		Arch:                result.Arch,
+		Filename:            result.Filename,
+		URL:                 result.DownloadURL,
+		SHASum:              result.SHASum,
+		SHASumsURL:          result.SHASumsURL,
+		SHASumsSignatureURL: result.SHASumsSignatureURL,
+	}
+	for _, key := range result.SigningKeys.GPGPublicKeys {
+		build.SigningKeys = append(build.SigningKeys, key.ASCIIArmor)
+	}
+
+	return build, nil
+}
+
+// providerEndpoint returns the URL of a provider registry endpoint, discovering where the
+// registry serves the providers API the first time it is called.
+func (s *RegistryReleaseSource) providerEndpoint(ctx context.Context, namespace, providerType string, parts ...string) (string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.providersURL == nil {
+		base, err := url.Parse(s.BaseURL + "/")
+		if err != nil {
+			return "", err
+		}
+
+		var discovery struct {
+			Providers string `json:"providers.v1"`
+		}
+
+		if err := getJSON(ctx, base.JoinPath(".well-known", "terraform.json").String(), &discovery); err != nil {
+			return "", err
+		}
+
+		if discovery.Providers == "" {
+			return "", fmt.Errorf("registry %s does not support the provider registry protocol", s.BaseURL)
+		}
+
+		ref, err := url.Parse(discovery.Providers)
+		if err != nil {
+			return "", err
+		}
+
+		s.providersURL = base.ResolveReference(ref)
+	}
+
+	return s.providersURL.JoinPath(append([]string{namespace, providerType}, parts...)...).String(), nil
+}
+
+// parseRegistryRelease returns the namespace and type of the provider the release refers to.
+func parseRegistryRelease(release string) (string, string, error) {
+	if providerType, ok := strings.CutPrefix(release, "terraform-provider-"); ok {
+		return "hashicorp", providerType, nil
+	}
+
+	parts := strings.Split(release, "/")
+	if len(parts) == 3 {
+		parts = parts[1:]
+	}
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%s is not a provider that can be found in a registry", release)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// StaticReleaseSource is a ReleaseSource backed by a fixed index of builds, keyed by release
+// name and then version, such as one loaded from a JSON file with LoadStaticReleaseSourceE.
+type StaticReleaseSource struct {
+	Releases map[string]map[string][]Build
+}
+
+// NewStaticReleaseSource returns a ReleaseSource that serves the given index of builds.
+func NewStaticReleaseSource(releases map[string]map[string][]Build) *StaticReleaseSource {
+	return &StaticReleaseSource{Releases: releases}
+}
+
+// LoadStaticReleaseSourceE reads a JSON index of builds from the given file, or returns an
+// error if the file cannot be read.
+//
+// The index is an object keyed by release name and then version, listing the builds of
+// each version:
+//
+//	{"terraform": {"1.5.7": [{"os": "linux", "arch": "amd64", "url": "...", "shasum": "..."}]}}
+func LoadStaticReleaseSourceE(filename string) (*StaticReleaseSource, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases map[string]map[string][]Build
+	if err := json.Unmarshal(content, &releases); err != nil {
+		return nil, fmt.Errorf("error when parsing release index %s: %w", filename, err)
+	}
+
+	return NewStaticReleaseSource(releases), nil
+}
+
+// ListVersions returns every version of the release in the index, in ascending order.
+func (s *StaticReleaseSource) ListVersions(ctx context.Context, release string) ([]string, error) {
+	versions := make([]string, 0, len(s.Releases[release]))
+	for ver := range s.Releases[release] {
+		versions = append(versions, ver)
+	}
+
+	return sortVersionStrings(versions)
+}
+
+// GetBuild returns the build of the release version for the os and architecture from the index.
+func (s *StaticReleaseSource) GetBuild(ctx context.Context, release, version, goos, goarch string) (*Build, error) {
+	for _, build := range s.Releases[release][version] {
+		if build.OS == goos && build.Arch == goarch {
+			build := build
+			if build.Filename == "" {
+				build.Filename = path.Base(build.URL)
+			}
+			return &build, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no %s %s build found for %s_%s", release, version, goos, goarch)
+}
+
+// getJSON fetches the given URL and decodes the JSON response into v.
+func getJSON(ctx context.Context, req string, v interface{}) error {
+	body, err := getBody(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error when parsing the response from %s: %w", req, err)
+	}
+
+	return nil
+}
+
+// getBody fetches the given URL and returns the response body, retrying transient failures
+// according to the RetryPolicy, or returns an error if the request fails or doesn't return a
+// successful response.
+func getBody(ctx context.Context, req string) ([]byte, error) {
+	var body []byte
+	err := withRetriesE(ctx, func() error {
+		ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+		defer cancel()
+
+		resp, err := doGetE(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+		return err
+	})
+
+	return body, err
+}
+
+// fetchSHA256SumsE fetches the build's SHA256SUMS file and sets its checksum from it, keeping
+// the file so that its signature can be verified without fetching it again.
+func (b *Build) fetchSHA256SumsE(ctx context.Context) error {
+	content, err := getBody(ctx, b.SHASumsURL)
+	if err != nil {
+		return err
+	}
+
+	b.shaSums = content
+	b.SHASum = parseSHA256Sums(content)[b.Filename]
+	return nil
+}
+
+// parseSHA256Sums parses the content of a SHA256SUMS file into checksums by filename. Lines
+// that aren't a hex encoded SHA256 checksum followed by a filename are ignored.
+func parseSHA256Sums(content []byte) map[string]string {
+	sums := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if sum, err := hex.DecodeString(fields[0]); err != nil || len(sum) != sha256.Size {
+			continue
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+
+	return sums
+}
@@ -0,0 +1,250 @@
+package testhelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFastRetries retries failed requests without waiting until the test finishes.
+func useFastRetries(t *testing.T) {
+	previous := GetRetryPolicy()
+	t.Cleanup(func() { SetRetryPolicy(previous) })
+
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+}
+
+func TestHashicorpReleaseSourcePagination(t *testing.T) {
+	// 45 releases, newest first, each created an hour before the one after it.
+	newest := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var releases []map[string]interface{}
+	for i := 44; i >= 0; i-- {
+		releases = append(releases, map[string]interface{}{
+			"version":           fmt.Sprintf("1.%d.0", i),
+			"timestamp_created": newest.Add(time.Duration(i-44) * time.Hour).Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+
+	var afters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/releases/terraform" {
+			http.NotFound(w, r)
+			return
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		after := r.URL.Query().Get("after")
+		afters = append(afters, after)
+
+		page := []map[string]interface{}{}
+		for _, release := range releases {
+			if after != "" && release["timestamp_created"].(string) >= after {
+				continue
+			}
+			if len(page) == limit {
+				break
+			}
+			page = append(page, release)
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	src := &HashicorpReleaseSource{BaseURL: server.URL}
+	versions, err := src.ListVersions(context.Background(), "terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != len(releases) {
+		t.Fatalf("expected %d versions, got %d: %v", len(releases), len(versions), versions)
+	}
+	for i, release := range releases {
+		if versions[i] != release["version"] {
+			t.Errorf("expected version %d to be %s, got %s", i, release["version"], versions[i])
+		}
+	}
+
+	// Three pages of 20, 20 and 5 releases, and an empty page ending the listing.
+	wantAfters := []string{
+		"",
+		releases[19]["timestamp_created"].(string),
+		releases[39]["timestamp_created"].(string),
+		releases[44]["timestamp_created"].(string),
+	}
+	if !reflect.DeepEqual(afters, wantAfters) {
+		t.Errorf("expected pages after %v, got %v", wantAfters, afters)
+	}
+}
+
+func TestRegistryReleaseSourceDiscovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers func(serverURL string) string
+	}{
+		{name: "relative", providers: func(string) string { return "/custom/v1/providers/" }},
+		{name: "absolute", providers: func(serverURL string) string { return serverURL + "/custom/v1/providers/" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discoveries := 0
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/.well-known/terraform.json":
+					discoveries++
+					_ = json.NewEncoder(w).Encode(map[string]string{"providers.v1": tt.providers(server.URL)})
+				case "/custom/v1/providers/example/test/versions":
+					fmt.Fprint(w, `{"versions": [{"version": "1.0.0"}, {"version": "1.1.0"}]}`)
+				case "/custom/v1/providers/example/test/1.1.0/download/linux/amd64":
+					fmt.Fprint(w, `{
+						"os": "linux",
+						"arch": "amd64",
+						"filename": "terraform-provider-test_1.1.0_linux_amd64.zip",
+						"download_url": "https://example.com/terraform-provider-test_1.1.0_linux_amd64.zip",
+						"shasums_url": "https://example.com/SHA256SUMS",
+						"shasums_signature_url": "https://example.com/SHA256SUMS.sig",
+						"shasum": "abc",
+						"signing_keys": {"gpg_public_keys": [{"ascii_armor": "key"}]}
+					}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			src := NewRegistryReleaseSource(server.URL + "/")
+
+			versions, err := src.ListVersions(context.Background(), "example/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"1.0.0", "1.1.0"}; !reflect.DeepEqual(versions, want) {
+				t.Errorf("expected versions %v, got %v", want, versions)
+			}
+
+			build, err := src.GetBuild(context.Background(), "example/test", "1.1.0", "linux", "amd64")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &Build{
+				OS:                  "linux",
+				Arch:                "amd64",
+				Filename:            "terraform-provider-test_1.1.0_linux_amd64.zip",
+				URL:                 "https://example.com/terraform-provider-test_1.1.0_linux_amd64.zip",
+				SHASum:              "abc",
+				SHASumsURL:          "https://example.com/SHA256SUMS",
+				SHASumsSignatureURL: "https://example.com/SHA256SUMS.sig",
+				SigningKeys:         []string{"key"},
+			}
+			if !reflect.DeepEqual(build, want) {
+				t.Errorf("expected build %+v, got %+v", want, build)
+			}
+
+			if discoveries != 1 {
+				t.Errorf("expected the registry to be discovered once, got %d", discoveries)
+			}
+		})
+	}
+}
+
+func TestRegistryReleaseSourceWithoutProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"modules.v1": "/v1/modules/"}`)
+	}))
+	defer server.Close()
+
+	_, err := NewRegistryReleaseSource(server.URL).ListVersions(context.Background(), "example/test")
+	if err == nil || !strings.Contains(err.Error(), "does not support the provider registry protocol") {
+		t.Fatalf("expected an unsupported registry error, got %v", err)
+	}
+}
+
+func TestReleaseSourceHTTPErrors(t *testing.T) {
+	useFastRetries(t)
+
+	tests := []struct {
+		status   int
+		attempts int
+	}{
+		{status: http.StatusNotFound, attempts: 1},
+		{status: http.StatusForbidden, attempts: 1},
+		{status: http.StatusTooManyRequests, attempts: 3},
+		{status: http.StatusBadGateway, attempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				http.Error(w, "something went wrong", tt.status)
+			}))
+			defer server.Close()
+
+			src := &HashicorpReleaseSource{BaseURL: server.URL}
+			_, err := src.ListVersions(context.Background(), "terraform")
+
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("expected an HTTPError, got %v", err)
+			}
+			if httpErr.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, httpErr.StatusCode)
+			}
+			if httpErr.Body != "something went wrong" {
+				t.Errorf("expected the response body in the error, got %q", httpErr.Body)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestParseSHA256Sums(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{
+			name:    "valid",
+			content: sum + "  terraform_1.5.7_linux_amd64.zip\n" + sum + " *terraform_1.5.7_darwin_arm64.zip\n",
+			want: map[string]string{
+				"terraform_1.5.7_linux_amd64.zip":  sum,
+				"terraform_1.5.7_darwin_arm64.zip": sum,
+			},
+		},
+		{name: "empty", content: "", want: map[string]string{}},
+		{name: "missing filename", content: sum + "\n", want: map[string]string{}},
+		{name: "extra fields", content: sum + "  a.zip b.zip\n", want: map[string]string{}},
+		{name: "short checksum", content: "abcdef  a.zip\n", want: map[string]string{}},
+		{name: "not hex", content: strings.Repeat("zz", 32) + "  a.zip\n", want: map[string]string{}},
+		{name: "html", content: "<html><body>Not Found</body></html>\n", want: map[string]string{}},
+		{
+			name:    "malformed lines among valid ones",
+			content: "garbage\n\n" + sum + "  a.zip\r\nabc  b.zip\n",
+			want:    map[string]string{"a.zip": sum},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSHA256Sums([]byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
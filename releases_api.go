@@ -0,0 +1,434 @@
+package testhelpers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// releasesAPITimeFormat is the format of the timestamps the HashiCorp releases API returns.
+const releasesAPITimeFormat = "2006-01-02T15:04:05.000Z"
+
+// ReleasesAPI is an http.Handler that stands in for the HashiCorp releases API and the release
+// downloads it links to, backed by a directory laid out like releases.hashicorp.com:
+//
+//	<dir>/<release>/<version>/<release>_<version>_<os>_<arch>.zip
+//
+// A <release>_<version>_SHA256SUMS file, and its .sig signature, are served when present in a
+// version directory, and generated from the archives otherwise. Point HashicorpReleaseSource at
+// it to discover and download releases without network access.
+//
+// Use WriteCachedReleasesE to lay out the engine binaries already in a Cache, such as those
+// downloaded with DownloadTerraformVersionE, as a directory it can serve.
+//
+// Terraform downloads are verified against HashicorpSigningKey by default, so serve a .sig made
+// with a key trusted using SetReleaseSigningKeys, or call SetReleaseSigningKeys("terraform") to
+// stop verifying them.
+type ReleasesAPI struct {
+	// Dir is the directory of release archives to serve.
+	Dir string
+}
+
+// NewReleasesAPI returns a ReleasesAPI serving the release archives in dir.
+func NewReleasesAPI(dir string) *ReleasesAPI {
+	return &ReleasesAPI{Dir: dir}
+}
+
+type releasesAPIBuild struct {
+	Arch string `json:"arch"`
+	OS   string `json:"os"`
+	URL  string `json:"url"`
+}
+
+type releasesAPIRelease struct {
+	Name                 string             `json:"name"`
+	Version              string             `json:"version"`
+	CreatedAt            string             `json:"timestamp_created"`
+	IsPrerelease         bool               `json:"is_prerelease"`
+	Builds               []releasesAPIBuild `json:"builds"`
+	SHASumsURL           string             `json:"url_shasums,omitempty"`
+	SHASumsSignatureURLs []string           `json:"url_shasums_signatures,omitempty"`
+}
+
+// ServeHTTP serves /v1/releases/<release>, /v1/releases/<release>/<version> and the files of
+// each release at /<release>/<version>/<filename>.
+func (a *ReleasesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "v1" && parts[1] == "releases":
+		a.serveList(w, r, parts[2])
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "releases":
+		a.serveRelease(w, r, parts[2], parts[3])
+	case len(parts) == 3:
+		a.serveFile(w, r, parts[0], parts[1], parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveList serves the releases newest first, limit at a time, starting with those created
+// before the after timestamp when it is given.
+func (a *ReleasesAPI) serveList(w http.ResponseWriter, r *http.Request, release string) {
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 20 {
+			http.Error(w, "limit must be between 1 and 20", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var after time.Time
+	if timestamp := r.URL.Query().Get("after"); timestamp != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			http.Error(w, "after must be a timestamp", http.StatusBadRequest)
+			return
+		}
+		after = parsed
+	}
+
+	releases, err := a.listReleasesE(release)
+	if err != nil {
+		serveFileError(w, r, err)
+		return
+	}
+
+	page := []releasesAPIRelease{}
+	for _, rel := range releases {
+		if len(page) == limit {
+			break
+		}
+
+		createdAt, _ := time.Parse(releasesAPITimeFormat, rel.CreatedAt)
+		if !after.IsZero() && !createdAt.Before(after) {
+			continue
+		}
+
+		rel, err := a.getReleaseE(r, release, rel.Version, createdAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page = append(page, *rel)
+	}
+
+	serveJSON(w, page)
+}
+
+func (a *ReleasesAPI) serveRelease(w http.ResponseWriter, r *http.Request, release, ver string) {
+	releases, err := a.listReleasesE(release)
+	if err != nil {
+		serveFileError(w, r, err)
+		return
+	}
+
+	for _, rel := range releases {
+		if rel.Version != ver {
+			continue
+		}
+
+		createdAt, _ := time.Parse(releasesAPITimeFormat, rel.CreatedAt)
+		rel, err := a.getReleaseE(r, release, ver, createdAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveJSON(w, rel)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func (a *ReleasesAPI) serveFile(w http.ResponseWriter, r *http.Request, release, ver, filename string) {
+	dir := filepath.Join(a.Dir, release, ver)
+
+	if filename == sha256SumsFilename(release, ver) {
+		if _, err := os.Stat(filepath.Join(dir, filename)); os.IsNotExist(err) {
+			sums, err := generateSHA256SumsE(dir)
+			if err != nil {
+				serveFileError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write(sums)
+			return
+		}
+	}
+
+	http.ServeFile(w, r, filepath.Join(dir, filename))
+}
+
+// listReleasesE returns the versions of the release in the directory, newest first, with only
+// their version and creation time set. The creation time is the modification time of the
+// version directory, adjusted so that every release has a distinct time older than the
+// release before it, which the after parameter needs to page through them.
+func (a *ReleasesAPI) listReleasesE(release string) ([]releasesAPIRelease, error) {
+	entries, err := os.ReadDir(filepath.Join(a.Dir, release))
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		version *version.Version
+		modTime time.Time
+	}
+
+	var versions []entry
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		v, err := version.NewVersion(e.Name())
+		if err != nil || v.Original() != e.Name() {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, entry{version: v, modTime: info.ModTime().UTC().Truncate(time.Millisecond)})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version.GreaterThan(versions[j].version)
+	})
+
+	releases := make([]releasesAPIRelease, 0, len(versions))
+	var previous time.Time
+	for i, v := range versions {
+		createdAt := v.modTime
+		if i > 0 && !createdAt.Before(previous) {
+			createdAt = previous.Add(-time.Millisecond)
+		}
+		previous = createdAt
+
+		releases = append(releases, releasesAPIRelease{
+			Version:   v.version.Original(),
+			CreatedAt: createdAt.Format(releasesAPITimeFormat),
+		})
+	}
+
+	return releases, nil
+}
+
+// getReleaseE describes the release version, with absolute URLs on the server handling r.
+func (a *ReleasesAPI) getReleaseE(r *http.Request, release, ver string, createdAt time.Time) (*releasesAPIRelease, error) {
+	dir := filepath.Join(a.Dir, release, ver)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s/%s/%s/", scheme, r.Host, release, ver)
+
+	v, err := version.NewVersion(ver)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := &releasesAPIRelease{
+		Name:         release,
+		Version:      ver,
+		CreatedAt:    createdAt.Format(releasesAPITimeFormat),
+		IsPrerelease: v.Prerelease() != "",
+		Builds:       []releasesAPIBuild{},
+		SHASumsURL:   baseURL + sha256SumsFilename(release, ver),
+	}
+
+	prefix := release + "_" + ver + "_"
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir():
+			continue
+		case name == sha256SumsFilename(release, ver)+".sig":
+			rel.SHASumsSignatureURLs = append(rel.SHASumsSignatureURLs, baseURL+name)
+		case strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".zip"):
+			platform, err := ParsePlatformE(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".zip"))
+			if err != nil {
+				continue
+			}
+			rel.Builds = append(rel.Builds, releasesAPIBuild{Arch: platform.Arch, OS: platform.OS, URL: baseURL + name})
+		}
+	}
+
+	return rel, nil
+}
+
+// sha256SumsFilename returns the name of the SHA256SUMS file of a release version.
+func sha256SumsFilename(release, ver string) string {
+	return release + "_" + ver + "_SHA256SUMS"
+}
+
+// generateSHA256SumsE returns the content of a SHA256SUMS file for the archives in dir.
+func generateSHA256SumsE(dir string) ([]byte, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Strings(matches)
+
+	var sums strings.Builder
+	for _, match := range matches {
+		sum, err := hashFileE(match)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(&sums, "%s  %s\n", sum, filepath.Base(match))
+	}
+
+	return []byte(sums.String()), nil
+}
+
+// WriteCachedReleasesE writes a release archive for every engine binary in the cache, such as
+// the Terraform binaries downloaded with DownloadTerraformVersionE, into dir in the layout a
+// ReleasesAPI serves. The archives are built from the cached binaries, so their SHA256SUMS
+// files are generated and unsigned: sign them with a key trusted using SetReleaseSigningKeys, or
+// opt the engines out of signature verification, before downloading from the ReleasesAPI.
+//
+// Usage:
+// * cache is the cache to read the engine binaries from, such as GetCache().
+// * dir is the directory to write the release archives to.
+func WriteCachedReleasesE(cache *Cache, dir string) error {
+	artifacts, err := cache.listTerraform()
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		release := artifact.Source
+		if release == "" {
+			release = "terraform"
+		}
+
+		platform, err := ParsePlatformE(artifact.Platform)
+		if err != nil {
+			return err
+		}
+
+		binaryName := release
+		if platform.OS == "windows" {
+			binaryName += ".exe"
+		}
+
+		versionDir := filepath.Join(dir, release, artifact.Version)
+		if err := os.MkdirAll(versionDir, 0o755); err != nil {
+			return err
+		}
+
+		filename := filepath.Join(versionDir, release+"_"+artifact.Version+"_"+platform.String()+".zip")
+		if err := writeEngineArchiveE(filename, artifact.Path, binaryName); err != nil {
+			return fmt.Errorf("error when writing the release archive of %s: %w", artifact.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteCachedReleases writes a release archive for every engine binary in the cache into dir in
+// the layout a ReleasesAPI serves, or fails the test if something goes wrong.
+//
+// Usage:
+// * cache is the cache to read the engine binaries from, such as GetCache().
+// * dir is the directory to write the release archives to.
+func WriteCachedReleases(t *testing.T, cache *Cache, dir string) {
+	if err := WriteCachedReleasesE(cache, dir); err != nil {
+		t.Fatalf("error when attempting to write the cached releases: %s", err)
+	}
+}
+
+// writeEngineArchiveE writes a zip archive to filename holding the binary under the given name,
+// keeping its mode so that it stays executable once unpacked.
+func writeEngineArchiveE(filename, binary, name string) (err error) {
+	in, err := os.Open(binary)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	zw := zip.NewWriter(out)
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, in); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// StartReleasesAPI starts a stand-in for the HashiCorp releases API serving the release archives
+// in dir, which is shut down when the test and all its subtests finish. Use it with
+// SetReleaseSource(&HashicorpReleaseSource{BaseURL: server.URL}).
+//
+// Usage:
+// * dir is the directory of release archives to serve.
+func StartReleasesAPI(t *testing.T, dir string) *httptest.Server {
+	server := httptest.NewServer(NewReleasesAPI(dir))
+	t.Cleanup(server.Close)
+
+	return server
+}
@@ -0,0 +1,224 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestRelease writes a release archive holding a binary named after the release into the
+// directory served by a ReleasesAPI, returning the directory of the release version.
+func writeTestRelease(t *testing.T, dir, release, ver string, platform Platform, content string) string {
+	t.Helper()
+
+	binary := release
+	if platform.OS == "windows" {
+		binary += ".exe"
+	}
+
+	versionDir := filepath.Join(dir, release, ver)
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(versionDir, release+"_"+ver+"_"+platform.String()+".zip")
+	if err := os.WriteFile(filename, newTestZip(t, binary, content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return versionDir
+}
+
+// signTestRelease signs the SHA256SUMS the ReleasesAPI generates for the release version.
+func signTestRelease(t *testing.T, key *testSigningKey, versionDir, release, ver string) {
+	t.Helper()
+
+	sums, err := generateSHA256SumsE(versionDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := filepath.Join(versionDir, sha256SumsFilename(release, ver)+".sig")
+	if err := os.WriteFile(sig, key.Sign(t, sums), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleasesAPIDownload(t *testing.T) {
+	restoreSigningKeys(t)
+	cache := useTestCache(t)
+
+	key := newTestSigningKey(t)
+	SetReleaseSigningKeys("terraform", key.Armored)
+
+	dir := t.TempDir()
+	platform := CurrentPlatform()
+	for _, ver := range []string{"1.4.0", "1.5.7", "1.6.0-rc1"} {
+		versionDir := writeTestRelease(t, dir, "terraform", ver, platform, "terraform "+ver)
+		signTestRelease(t, key, versionDir, "terraform", ver)
+	}
+
+	server := StartReleasesAPI(t, dir)
+	engine := TerraformEngine()
+	engine.ReleaseSource = &HashicorpReleaseSource{BaseURL: server.URL}
+
+	versions, err := GetAvailableEngineVersionsContextE(context.Background(), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.4.0", "1.5.7"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("expected versions %v, got %v", want, versions)
+	}
+
+	binaryPath, err := DownloadEngineVersionContextE(context.Background(), engine, "1.5.7", platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := engineBinaryPath(cache.TerraformDir, "terraform", "1.5.7", platform); binaryPath != want {
+		t.Errorf("expected the binary at %s, got %s", want, binaryPath)
+	}
+
+	content, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "terraform 1.5.7" {
+		t.Errorf("unexpected binary content %q", content)
+	}
+}
+
+func TestReleasesAPIDownloadVerification(t *testing.T) {
+	platform := CurrentPlatform()
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, key *testSigningKey, versionDir string)
+		check  func(err error) bool
+	}{
+		{
+			name: "unsigned",
+			check: func(err error) bool {
+				var sigErr *SignatureError
+				return errors.As(err, &sigErr)
+			},
+		},
+		{
+			name: "signed by an untrusted key",
+			modify: func(t *testing.T, _ *testSigningKey, versionDir string) {
+				signTestRelease(t, newTestSigningKey(t), versionDir, "terraform", "1.5.7")
+			},
+			check: func(err error) bool {
+				var sigErr *SignatureError
+				return errors.As(err, &sigErr)
+			},
+		},
+		{
+			name: "checksum mismatch",
+			modify: func(t *testing.T, key *testSigningKey, versionDir string) {
+				signTestRelease(t, key, versionDir, "terraform", "1.5.7")
+
+				// Replace the archive with another once the published SHA256SUMS is signed.
+				sums, err := generateSHA256SumsE(versionDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestRelease(t, filepath.Dir(filepath.Dir(versionDir)), "terraform", "1.5.7", platform, "tampered")
+				if err := os.WriteFile(filepath.Join(versionDir, sha256SumsFilename("terraform", "1.5.7")), sums, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(err error) bool {
+				var mismatch *ChecksumMismatchError
+				return errors.As(err, &mismatch)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreSigningKeys(t)
+			cache := useTestCache(t)
+
+			key := newTestSigningKey(t)
+			SetReleaseSigningKeys("terraform", key.Armored)
+
+			dir := t.TempDir()
+			versionDir := writeTestRelease(t, dir, "terraform", "1.5.7", platform, "terraform 1.5.7")
+			if tt.modify != nil {
+				tt.modify(t, key, versionDir)
+			}
+
+			server := StartReleasesAPI(t, dir)
+			engine := TerraformEngine()
+			engine.ReleaseSource = &HashicorpReleaseSource{BaseURL: server.URL}
+
+			_, err := DownloadEngineVersionContextE(context.Background(), engine, "1.5.7", platform)
+			if !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if _, err := os.Stat(engineBinaryPath(cache.TerraformDir, "terraform", "1.5.7", platform)); !os.IsNotExist(err) {
+				t.Errorf("expected nothing to be cached, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteCachedReleases(t *testing.T) {
+	restoreSigningKeys(t)
+
+	key := newTestSigningKey(t)
+	SetReleaseSigningKeys("terraform", key.Armored)
+
+	upstreamDir := t.TempDir()
+	platform := CurrentPlatform()
+	versionDir := writeTestRelease(t, upstreamDir, "terraform", "1.5.7", platform, "terraform 1.5.7")
+	signTestRelease(t, key, versionDir, "terraform", "1.5.7")
+
+	previousSource := GetReleaseSource()
+	t.Cleanup(func() { SetReleaseSource(previousSource) })
+	SetReleaseSource(&HashicorpReleaseSource{BaseURL: StartReleasesAPI(t, upstreamDir).URL})
+
+	// Fill a cache the way tests do, then serve it.
+	cache := useTestCache(t)
+	if _, err := DownloadTerraformVersionE("1.5.7"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := WriteCachedReleasesE(cache, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archives are rebuilt from the cached binaries, so they aren't signed.
+	SetReleaseSigningKeys("terraform")
+	other := useTestCache(t)
+	engine := TerraformEngine()
+	engine.ReleaseSource = &HashicorpReleaseSource{BaseURL: StartReleasesAPI(t, dir).URL}
+
+	versions, err := GetAvailableEngineVersionsContextE(context.Background(), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.5.7"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("expected versions %v, got %v", want, versions)
+	}
+
+	binaryPath, err := DownloadEngineVersionContextE(context.Background(), engine, "1.5.7", platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := engineBinaryPath(other.TerraformDir, "terraform", "1.5.7", platform); binaryPath != want {
+		t.Errorf("expected the binary at %s, got %s", want, binaryPath)
+	}
+
+	content, err := os.ReadFile(binaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "terraform 1.5.7" {
+		t.Errorf("expected the cached binary, got %q", content)
+	}
+}
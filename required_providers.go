@@ -0,0 +1,391 @@
+package testhelpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// RequiredProvider is a provider declared in the required_providers blocks of a module.
+type RequiredProvider struct {
+	// Name is the provider's local name in the module, such as "aws".
+	Name string
+	// Source is the provider's declared source address, such as "hashicorp/aws". It is empty
+	// when the module doesn't declare one, in which case Terraform implies "hashicorp/<name>".
+	Source string
+	// VersionConstraints are the version constraints declared for the provider, in the order
+	// they were found. A version must satisfy all of them.
+	VersionConstraints []string
+	// ConfigurationAliases are the provider configurations the module expects to be passed in,
+	// such as "aws.east".
+	ConfigurationAliases []string
+	// DeclRanges are where the provider is declared.
+	DeclRanges []hcl.Range
+}
+
+// SourceAddress returns the provider's declared source address, or the address Terraform
+// implies from its local name when none is declared.
+func (p *RequiredProvider) SourceAddress() string {
+	if p.Source == "" {
+		return "hashicorp/" + p.Name
+	}
+	return p.Source
+}
+
+// VersionConstraint returns the intersection of the provider's version constraints as a single
+// constraint, or an empty string if it has none.
+func (p *RequiredProvider) VersionConstraint() string {
+	return strings.Join(p.VersionConstraints, ", ")
+}
+
+// moduleFileSchema is the part of a Terraform configuration file that declares the Terraform
+// versions and providers the module requires.
+type moduleFileSchema struct {
+	Terraform []struct {
+		RequiredVersion   *string `hcl:"required_version,optional"`
+		RequiredProviders []struct {
+			Config hcl.Body `hcl:",remain"`
+		} `hcl:"required_providers,block"`
+		Remain hcl.Body `hcl:",remain"`
+	} `hcl:"terraform,block"`
+	Remain hcl.Body `hcl:",remain"`
+}
+
+// GetRequiredProvidersE returns every provider declared in the required_providers blocks of
+// the module, in alphabetical order of their local names. The version constraints declared for
+// a provider in several files are combined, and override files replace a provider's
+// declaration as they do in Terraform.
+//
+// Both the object form of a declaration and the legacy string form, which only holds a version
+// constraint, are supported. Invalid declarations are returned as hcl.Diagnostics pointing at
+// the file and line they are found on.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+func GetRequiredProvidersE(srcDir string) ([]*RequiredProvider, error) {
+	files, diags, err := parseModuleFilesE(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := map[string]*RequiredProvider{}
+	var overrides []*RequiredProvider
+
+	for _, f := range files {
+		declared, declDiags := decodeRequiredProviders(f.Body)
+		diags = append(diags, declDiags...)
+
+		if f.Override {
+			overrides = append(overrides, declared...)
+			continue
+		}
+
+		for _, p := range declared {
+			diags = append(diags, mergeRequiredProvider(providers, p)...)
+		}
+	}
+
+	// Override files are applied after the rest of the module, replacing the whole declaration.
+	for _, p := range overrides {
+		providers[p.Name] = p
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*RequiredProvider, 0, len(names))
+	for _, name := range names {
+		result = append(result, providers[name])
+	}
+	return result, nil
+}
+
+// moduleFile is a parsed Terraform configuration file of a module.
+type moduleFile struct {
+	*hcl.File
+	// Name is the file's name within the module directory.
+	Name string
+	// Override is true for override files, whose settings replace those of the other files.
+	Override bool
+}
+
+// parseModuleFilesE parses the .tf and .tf.json files of the module in the order Terraform
+// loads them. Files that cannot be parsed are left out, and their problems returned as
+// diagnostics.
+func parseModuleFilesE(srcDir string) ([]moduleFile, hcl.Diagnostics, error) {
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parser := hclparse.NewParser()
+	var files []moduleFile
+	var diags hcl.Diagnostics
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		filename := filepath.Join(srcDir, entry.Name())
+
+		var f *hcl.File
+		var parseDiags hcl.Diagnostics
+		switch {
+		case strings.HasSuffix(entry.Name(), ".tf"):
+			f, parseDiags = parser.ParseHCLFile(filename)
+		case strings.HasSuffix(entry.Name(), ".tf.json"):
+			f, parseDiags = parser.ParseJSONFile(filename)
+		default:
+			continue
+		}
+		diags = append(diags, parseDiags...)
+		if parseDiags.HasErrors() {
+			continue
+		}
+
+		files = append(files, moduleFile{
+			File:     f,
+			Name:     entry.Name(),
+			Override: isOverrideFile(strings.TrimSuffix(entry.Name(), ".json")),
+		})
+	}
+
+	return files, diags, nil
+}
+
+// GetRequiredProviders returns every provider declared in the required_providers blocks of the
+// module, or fails the test if they cannot be read.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+func GetRequiredProviders(t *testing.T, srcDir string) []*RequiredProvider {
+	providers, err := GetRequiredProvidersE(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return providers
+}
+
+// GetRequiredProviderE returns the provider declared with the given local name in the module,
+// or an error if the module cannot be read or doesn't declare it.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+// * provider is the local name of the provider.
+func GetRequiredProviderE(srcDir, provider string) (*RequiredProvider, error) {
+	providers, err := GetRequiredProvidersE(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range providers {
+		if p.Name == provider {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("provider %s not found", provider)
+}
+
+// GetRequiredProvider returns the provider declared with the given local name in the module,
+// or fails the test if the module cannot be read or doesn't declare it.
+//
+// Usage:
+// * srcDir is the directory that contains the Terraform source files.
+// * provider is the local name of the provider.
+func GetRequiredProvider(t *testing.T, srcDir, provider string) *RequiredProvider {
+	p, err := GetRequiredProviderE(srcDir, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// decodeRequiredProviders returns the providers declared in the required_providers blocks of
+// a single file.
+func decodeRequiredProviders(body hcl.Body) ([]*RequiredProvider, hcl.Diagnostics) {
+	var schema moduleFileSchema
+	diags := gohcl.DecodeBody(body, nil, &schema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var providers []*RequiredProvider
+	for _, terraform := range schema.Terraform {
+		for _, block := range terraform.RequiredProviders {
+			attrs, attrDiags := block.Config.JustAttributes()
+			diags = append(diags, attrDiags...)
+
+			// Attributes are returned as a map, so keep the declarations in source order.
+			sorted := make([]*hcl.Attribute, 0, len(attrs))
+			for _, attr := range attrs {
+				sorted = append(sorted, attr)
+			}
+			sort.Slice(sorted, func(i, j int) bool {
+				return sorted[i].Range.Start.Byte < sorted[j].Range.Start.Byte
+			})
+
+			for _, attr := range sorted {
+				p, declDiags := decodeRequiredProvider(attr)
+				diags = append(diags, declDiags...)
+				if p != nil {
+					providers = append(providers, p)
+				}
+			}
+		}
+	}
+
+	return providers, diags
+}
+
+// decodeRequiredProvider decodes a single declaration in a required_providers block, in either
+// the object form or the legacy string form.
+func decodeRequiredProvider(attr *hcl.Attribute) (*RequiredProvider, hcl.Diagnostics) {
+	p := &RequiredProvider{Name: attr.Name, DeclRanges: []hcl.Range{attr.Range}}
+
+	// The legacy form is a string holding only the version constraint.
+	if val, diags := attr.Expr.Value(nil); !diags.HasErrors() && val.Type() == cty.String {
+		var constraint string
+		if diags := gohcl.DecodeExpression(attr.Expr, nil, &constraint); diags.HasErrors() {
+			return nil, diags
+		}
+		p.VersionConstraints = []string{constraint}
+		return p, nil
+	}
+
+	// The object form can't be evaluated as a whole, as configuration_aliases holds references.
+	pairs, diags := hcl.ExprMap(attr.Expr)
+	if diags.HasErrors() {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid required_providers object",
+			Detail:   fmt.Sprintf("The declaration of provider %q must be an object with source, version and configuration_aliases attributes, or a version constraint string.", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		}}
+	}
+
+	for _, pair := range pairs {
+		key := hcl.ExprAsKeyword(pair.Key)
+		if key == "" {
+			if keyDiags := gohcl.DecodeExpression(pair.Key, nil, &key); keyDiags.HasErrors() {
+				diags = append(diags, keyDiags...)
+				continue
+			}
+		}
+
+		switch key {
+		case "source":
+			diags = append(diags, gohcl.DecodeExpression(pair.Value, nil, &p.Source)...)
+		case "version":
+			var constraint string
+			diags = append(diags, gohcl.DecodeExpression(pair.Value, nil, &constraint)...)
+			if constraint != "" {
+				p.VersionConstraints = append(p.VersionConstraints, constraint)
+			}
+		case "configuration_aliases":
+			aliases, aliasDiags := decodeConfigurationAliases(attr.Name, pair.Value)
+			diags = append(diags, aliasDiags...)
+			p.ConfigurationAliases = append(p.ConfigurationAliases, aliases...)
+		default:
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid required_providers object",
+				Detail:   fmt.Sprintf("The declaration of provider %q has an unexpected %q attribute. Only source, version and configuration_aliases are allowed.", attr.Name, key),
+				Subject:  pair.Key.Range().Ptr(),
+			})
+		}
+	}
+
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return p, diags
+}
+
+// decodeConfigurationAliases returns the provider configurations listed in a
+// configuration_aliases attribute, which must each be a reference like aws.east to a
+// configuration of the provider being declared.
+func decodeConfigurationAliases(name string, expr hcl.Expression) ([]string, hcl.Diagnostics) {
+	exprs, diags := hcl.ExprList(expr)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var aliases []string
+	for _, expr := range exprs {
+		traversal, travDiags := hcl.AbsTraversalForExpr(expr)
+		if travDiags.HasErrors() {
+			diags = append(diags, travDiags...)
+			continue
+		}
+
+		var alias hcl.TraverseAttr
+		if len(traversal) == 2 {
+			alias, _ = traversal[1].(hcl.TraverseAttr)
+		}
+		if alias.Name == "" || traversal.RootName() != name {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid configuration alias",
+				Detail:   fmt.Sprintf("Configuration aliases of provider %q must be references like %s.<alias>.", name, name),
+				Subject:  expr.Range().Ptr(),
+			})
+			continue
+		}
+
+		aliases = append(aliases, name+"."+alias.Name)
+	}
+
+	return aliases, diags
+}
+
+// mergeRequiredProvider adds a declaration of a provider to those found so far, combining the
+// version constraints and configuration aliases of repeated declarations. Declarations of the
+// same provider with different sources conflict.
+func mergeRequiredProvider(providers map[string]*RequiredProvider, p *RequiredProvider) hcl.Diagnostics {
+	existing, ok := providers[p.Name]
+	if !ok {
+		providers[p.Name] = p
+		return nil
+	}
+
+	if p.Source != "" && existing.Source != "" && !strings.EqualFold(p.Source, existing.Source) {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Conflicting provider source",
+			Detail:   fmt.Sprintf("Provider %q is declared with source %q here, but with source %q at %s.", p.Name, p.Source, existing.Source, existing.DeclRanges[0]),
+			Subject:  p.DeclRanges[0].Ptr(),
+		}}
+	}
+
+	if existing.Source == "" {
+		existing.Source = p.Source
+	}
+	existing.VersionConstraints = append(existing.VersionConstraints, p.VersionConstraints...)
+	existing.DeclRanges = append(existing.DeclRanges, p.DeclRanges...)
+
+	for _, alias := range p.ConfigurationAliases {
+		if !slices.Contains(existing.ConfigurationAliases, alias) {
+			existing.ConfigurationAliases = append(existing.ConfigurationAliases, alias)
+		}
+	}
+
+	return nil
+}
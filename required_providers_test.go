@@ -0,0 +1,221 @@
+package testhelpers
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// requiredProvidersConfig returns a terraform block with the given required_providers.
+func requiredProvidersConfig(providers string) string {
+	return "terraform {\n  required_providers {\n    " + providers + "\n  }\n}\n"
+}
+
+func TestGetRequiredProviders(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []RequiredProvider
+	}{
+		{
+			name: "object form",
+			files: map[string]string{"versions.tf": `
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+    random = {
+      source = "hashicorp/random"
+    }
+  }
+}`},
+			want: []RequiredProvider{
+				{Name: "aws", Source: "hashicorp/aws", VersionConstraints: []string{"~> 5.0"}},
+				{Name: "random", Source: "hashicorp/random"},
+			},
+		},
+		{
+			name:  "legacy string form",
+			files: map[string]string{"versions.tf": requiredProvidersConfig(`aws = "~> 5.0"`)},
+			want:  []RequiredProvider{{Name: "aws", VersionConstraints: []string{"~> 5.0"}}},
+		},
+		{
+			name: "json",
+			files: map[string]string{"versions.tf.json": `{
+  "terraform": {
+    "required_providers": {
+      "aws": {"source": "hashicorp/aws", "version": "~> 5.0"},
+      "null": "~> 3.2"
+    }
+  }
+}`},
+			want: []RequiredProvider{
+				{Name: "aws", Source: "hashicorp/aws", VersionConstraints: []string{"~> 5.0"}},
+				{Name: "null", VersionConstraints: []string{"~> 3.2"}},
+			},
+		},
+		{
+			name: "configuration aliases",
+			files: map[string]string{"versions.tf": `
+terraform {
+  required_providers {
+    aws = {
+      source                = "hashicorp/aws"
+      configuration_aliases = [aws.east, aws.west]
+    }
+  }
+}`},
+			want: []RequiredProvider{
+				{Name: "aws", Source: "hashicorp/aws", ConfigurationAliases: []string{"aws.east", "aws.west"}},
+			},
+		},
+		{
+			name: "declarations combined across files",
+			files: map[string]string{
+				"a.tf": requiredProvidersConfig(`aws = { source = "hashicorp/aws", version = ">= 4.0" }`),
+				"b.tf": requiredProvidersConfig(`aws = { version = "< 6.0", configuration_aliases = [aws.east] }`),
+			},
+			want: []RequiredProvider{
+				{Name: "aws", Source: "hashicorp/aws", VersionConstraints: []string{">= 4.0", "< 6.0"}, ConfigurationAliases: []string{"aws.east"}},
+			},
+		},
+		{
+			name: "override replaces the whole declaration",
+			files: map[string]string{
+				"a.tf": requiredProvidersConfig(`aws = { source = "hashicorp/aws", version = ">= 4.0", configuration_aliases = [aws.east] }`),
+				"b.tf": requiredProvidersConfig(`aws = { version = "< 6.0" }`),
+				"aws_override.tf": `
+terraform {
+  required_providers {
+    aws = {
+      source  = "example.com/example/aws"
+      version = "~> 1.0"
+    }
+  }
+}`,
+			},
+			want: []RequiredProvider{
+				{Name: "aws", Source: "example.com/example/aws", VersionConstraints: []string{"~> 1.0"}},
+			},
+		},
+		{
+			name: "json override",
+			files: map[string]string{
+				"a.tf":               requiredProvidersConfig(`aws = { source = "hashicorp/aws", version = ">= 4.0" }`),
+				"override.tf.json":   `{"terraform": {"required_providers": {"aws": "~> 5.0"}}}`,
+				"unrelated.tf":       requiredProvidersConfig(`null = "~> 3.2"`),
+				"unrelated.tf.jsonx": `not a configuration file`,
+			},
+			want: []RequiredProvider{
+				{Name: "aws", VersionConstraints: []string{"~> 5.0"}},
+				{Name: "null", VersionConstraints: []string{"~> 3.2"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers, err := GetRequiredProvidersE(writeTestModule(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]RequiredProvider, 0, len(providers))
+			for _, p := range providers {
+				if len(p.DeclRanges) == 0 {
+					t.Errorf("expected provider %s to record where it is declared", p.Name)
+				}
+				got = append(got, RequiredProvider{
+					Name:                 p.Name,
+					Source:               p.Source,
+					VersionConstraints:   p.VersionConstraints,
+					ConfigurationAliases: p.ConfigurationAliases,
+				})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetRequiredProvidersDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		file    string
+		summary string
+	}{
+		{
+			name:    "syntax error",
+			files:   map[string]string{"versions.tf": "terraform {\n  required_providers {\n"},
+			file:    "versions.tf",
+			summary: "Unclosed configuration block",
+		},
+		{
+			name:    "unexpected attribute",
+			files:   map[string]string{"versions.tf": requiredProvidersConfig(`aws = { source = "hashicorp/aws", versions = "~> 5.0" }`)},
+			file:    "versions.tf",
+			summary: "Invalid required_providers object",
+		},
+		{
+			name:    "not an object",
+			files:   map[string]string{"versions.tf": requiredProvidersConfig(`aws = 5`)},
+			file:    "versions.tf",
+			summary: "Invalid required_providers object",
+		},
+		{
+			name:    "alias of another provider",
+			files:   map[string]string{"versions.tf": requiredProvidersConfig(`aws = { configuration_aliases = [google.east] }`)},
+			file:    "versions.tf",
+			summary: "Invalid configuration alias",
+		},
+		{
+			name: "conflicting sources",
+			files: map[string]string{
+				"a.tf": requiredProvidersConfig(`aws = { source = "hashicorp/aws" }`),
+				"b.tf": requiredProvidersConfig(`aws = { source = "example.com/example/aws" }`),
+			},
+			file:    "b.tf",
+			summary: "Conflicting provider source",
+		},
+		{
+			name:    "invalid json",
+			files:   map[string]string{"versions.tf.json": `{"terraform": {"required_providers": {"aws": {"source": 5, "version": ["~> 5.0"]}}}}`},
+			file:    "versions.tf.json",
+			summary: "Unsuitable value type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestModule(t, tt.files)
+
+			_, err := GetRequiredProvidersE(dir)
+			var diags hcl.Diagnostics
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected hcl.Diagnostics, got %v", err)
+			}
+
+			var found bool
+			for _, diag := range diags {
+				if diag.Summary == tt.summary && diag.Subject != nil && diag.Subject.Filename == filepath.Join(dir, tt.file) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a %q diagnostic in %s, got %v", tt.summary, tt.file, err)
+			}
+
+			if !strings.Contains(err.Error(), tt.file) {
+				t.Errorf("expected the error to name %s, got %v", tt.file, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how network requests are retried when they fail with a transient error:
+// a timeout, a refused or reset connection, a response cut short, a 429 Too Many Requests
+// response or a 5xx response.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is made, including the first. Requests are not
+	// retried when it is less than 2.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, which doubles for each retry after it.
+	// Each wait is randomised between half and all of its value so that parallel tests don't
+	// retry in lockstep.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. Requests are not retried when the server asks
+	// for a longer wait with Retry-After.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used unless SetRetryPolicy has been called.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+var (
+	retryPolicyMx sync.RWMutex
+	retryPolicy   = DefaultRetryPolicy()
+)
+
+// SetRetryPolicy changes how the release listing, metadata and download requests the package
+// makes are retried.
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicyMx.Lock()
+	defer retryPolicyMx.Unlock()
+
+	retryPolicy = policy
+}
+
+// GetRetryPolicy returns how the requests the package makes are retried.
+func GetRetryPolicy() RetryPolicy {
+	retryPolicyMx.RLock()
+	defer retryPolicyMx.RUnlock()
+
+	return retryPolicy
+}
+
+// backoff returns how long to wait after the given attempt failed.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// withRetriesE calls fn until it succeeds, fails with an error that isn't transient, the retry
+// policy's attempts run out or ctx is done, returning the last error. A Retry-After given with
+// a 429 or 503 response is waited for instead of the policy's backoff, unless it is longer than
+// the policy's MaxBackoff, when the error is returned instead.
+func withRetriesE(ctx context.Context, fn func() error) error {
+	policy := GetRetryPolicy()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+
+		wait := policy.backoff(attempt)
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			if policy.MaxBackoff > 0 && httpErr.RetryAfter > policy.MaxBackoff {
+				return err
+			}
+			wait = httpErr.RetryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryableError reports whether a request that failed with err might succeed if it is made
+// again. Failures that will happen again however often the request is made, such as an invalid
+// URL, an unknown host or an untrusted certificate, are not retried.
+func isRetryableError(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests ||
+			(httpErr.StatusCode >= 500 && httpErr.StatusCode != http.StatusNotImplemented)
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter returns the wait a Retry-After header asks for, given either in seconds or
+// as an HTTP date, or zero if there is no valid header.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,84 @@
+package testhelpers
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	_, parseErr := url.Parse("http://[::1")
+
+	dialErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "too many requests", err: &HTTPError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "bad gateway", err: &HTTPError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "not implemented", err: &HTTPError{StatusCode: http.StatusNotImplemented}, want: false},
+		{name: "not found", err: &HTTPError{StatusCode: http.StatusNotFound}, want: false},
+		{name: "connection refused", err: dialErr(os.NewSyscallError("connect", syscall.ECONNREFUSED)), want: true},
+		{name: "connection reset", err: dialErr(os.NewSyscallError("read", syscall.ECONNRESET)), want: true},
+		{name: "timeout", err: dialErr(&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}), want: true},
+		{name: "response cut short", err: fmt.Errorf("error when downloading: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "unknown host", err: dialErr(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), want: false},
+		{name: "untrusted certificate", err: &url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, want: false},
+		{name: "unsupported scheme", err: &url.Error{Op: "Get", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}, want: false},
+		{name: "invalid url", err: parseErr, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.want {
+				t.Errorf("expected isRetryableError(%v) to be %t, got %t", tt.err, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWithRetriesRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		wantCalls  int
+	}{
+		{name: "within max backoff", retryAfter: time.Millisecond, wantCalls: 3},
+		{name: "longer than max backoff", retryAfter: time.Hour, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := GetRetryPolicy()
+			t.Cleanup(func() { SetRetryPolicy(previous) })
+			SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+
+			var calls int
+			err := withRetriesE(context.Background(), func() error {
+				calls++
+				return &HTTPError{StatusCode: http.StatusServiceUnavailable, RetryAfter: tt.retryAfter}
+			})
+
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("expected an HTTPError, got %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d attempts, got %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
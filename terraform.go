@@ -2,18 +2,15 @@ package testhelpers
 
 import (
 	"archive/zip"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
-	"time"
 
 	version "github.com/hashicorp/go-version"
 	hcl "github.com/hashicorp/hcl/v2"
@@ -22,78 +19,48 @@ import (
 )
 
 // GetAvailableVersionsE returns all of the versions available for the
-// given provider or the Terraform binary, or returns an error if something goes wrong
+// given provider or the Terraform binary in ascending semver order, or returns an error if
+// something goes wrong. Prereleases are left out unless the PrereleasePolicy includes them.
 func GetAvailableVersionsE(release string) ([]string, error) {
-	var versions []string
-
-	client := http.Client{Timeout: 5 * time.Second}
-	req := fmt.Sprintf("https://api.releases.hashicorp.com/v1/releases/%s?limit=20", release)
-
-	for {
-		resp, err := client.Get(req)
-		if err != nil {
-			return nil, err
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-		if err != nil {
-			return nil, err
-		}
-
-		var result []struct {
-			Version   string `json:"version"`
-			CreatedAt string `json:"timestamp_created"`
-		}
-
-		if err := json.Unmarshal(body, &result); err != nil {
-			return nil, err
-		}
-
-		if len(result) == 0 {
-			break
-		}
-
-		for _, res := range result {
-			versions = append(versions, res.Version)
-			req = fmt.Sprintf("https://api.releases.hashicorp.com/v1/releases/%s?limit=20&after=%s", release, res.CreatedAt)
-		}
-	}
+	return GetAvailableVersionsContextE(context.Background(), release)
+}
 
-	return versions, nil
+// GetAvailableVersionsContextE returns all of the versions available for the given provider or
+// the Terraform binary in ascending semver order, stopping when ctx is done, or returns an error
+// if something goes wrong. Listings are cached on disk for the version cache TTL, see SetVersionCacheTTL.
+func GetAvailableVersionsContextE(ctx context.Context, release string) ([]string, error) {
+	return listVersionsE(ctx, GetReleaseSource(), release)
 }
 
 // GetAvailableVersions returns all the released versions of a provider or the Terraform binary
 // or fails the test if something goes wrong
 func GetAvailableVersions(t *testing.T, release string) []string {
-	out, err := GetAvailableVersionsE(release)
+	out, err := GetAvailableVersionsContextE(testContext(t), release)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return out
 }
 
 // GetMatchingVersionsE returns a slice of the matching version strings that meet the
-// constraint criteria given, or an error if something goes wrong
+// constraint criteria given in ascending semver order and canonical form, such as 1.5.0 for
+// v1.5, or an error if something goes wrong.
+// Prereleases only match a constraint that names a prerelease, unless the PrereleasePolicy
+// includes them.
 func GetMatchingVersionsE(constraint string, versions []string) ([]string, error) {
 	want, err := version.NewConstraint(constraint)
 	if err != nil {
 		return nil, err
 	}
 
-	var vers []*version.Version
-	for _, ver := range versions {
-		vObj, err := version.NewVersion(ver)
-		if err != nil {
-			return nil, err
-		}
-
-		vers = append(vers, vObj)
+	vers, err := parseSortedVersions(versions)
+	if err != nil {
+		return nil, err
 	}
 
 	var matching []string
 	for _, ver := range vers {
-		if want.Check(ver) {
+		if checkConstraint(want, ver) {
 			matching = append(matching, ver.String())
 		}
 	}
@@ -106,7 +73,7 @@ func GetMatchingVersionsE(constraint string, versions []string) ([]string, error
 func GetMatchingVersions(t *testing.T, constraint string, versions []string) []string {
 	out, err := GetMatchingVersionsE(constraint, versions)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return out
 }
@@ -313,54 +280,32 @@ func UpdateProviderVersionE(dir, provider, version string, providerSource string
 func UpdateProviderVersion(t *testing.T, dir, provider, version string, providerSource string) {
 	err := UpdateProviderVersionE(dir, provider, version, providerSource)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 }
 
 // GetTerraformBinaryUrlE will return the correct download URL for the terraform binary version requested
-// based on the operating system and architecture by fetching it from the Hashicorp releases API
+// based on the operating system and architecture by fetching it from the configured ReleaseSource
 //
 // Usage:
 // * version is the version of Terraform to download.
 func GetTerraformBinaryUrlE(version string) (string, error) {
-	var binaryUrl string
-	operatingSystem := runtime.GOOS
-	architecture := runtime.GOARCH
+	return GetTerraformBinaryUrlContextE(context.Background(), version)
+}
 
-	releasesApiReq := fmt.Sprintf("https://api.releases.hashicorp.com/v1/releases/terraform/%s", version)
-	resp, err := http.Get(releasesApiReq)
-	if err != nil {
-		return "", err
-	}
-	body, err := io.ReadAll(resp.Body)
-	_ = resp.Body.Close()
+// GetTerraformBinaryUrlContextE will return the download URL for the terraform binary version
+// requested for the underlying operating system and architecture, stopping when ctx is done
+//
+// Usage:
+// * ctx is the context of the request.
+// * version is the version of Terraform to download.
+func GetTerraformBinaryUrlContextE(ctx context.Context, version string) (string, error) {
+	build, err := GetReleaseSource().GetBuild(ctx, "terraform", version, runtime.GOOS, runtime.GOARCH)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("Unable to find an appropriate Terraform binary download URL for the underlying OS and architecture: %w", err)
 	}
 
-	var result struct {
-		Builds []struct {
-			Arch string `json:"arch"`
-			Os   string `json:"os"`
-			Url  string `json:"url"`
-		}
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
-	}
-
-	for _, res := range result.Builds {
-		if res.Arch == architecture && res.Os == operatingSystem {
-			binaryUrl = res.Url
-		}
-	}
-
-	if len(binaryUrl) > 0 {
-		return binaryUrl, nil
-	} else {
-		return "", errors.New("Unable to find an appropriate Terraform binary download URL for the underlying OS and architecture")
-	}
+	return build.URL, nil
 }
 
 // Closure to address file descriptors issue with all the deferred .Close() methods
@@ -405,102 +350,33 @@ func extractAndWriteFile(dst string, f *zip.File) error {
 	return nil
 }
 
-// DownloadTerraformVersionE will download the specified version of Terraform into the ~/.terraform.versions directory
+// DownloadTerraformVersionE will download the specified version of Terraform into the Terraform cache (~/.terraform.versions by default),
+// verifying it against the checksums published for the release. It is safe to call from parallel tests.
 //
 // Usage:
 // * version is the version of Terraform to download.
 func DownloadTerraformVersionE(version string) (binaryPath string, err error) {
-	// Initialise all path variables
-	homeDirectory, _ := os.UserHomeDir()
-	binaryDownloadDirectory := filepath.Join(homeDirectory, ".terraform.versions")
-	binaryPath = binaryDownloadDirectory + "/terraform_" + version
-
-	// Don't do anything if the required binary already exists
-	if _, err := os.Stat(binaryPath); errors.Is(err, os.ErrNotExist) {
-
-		// Create ~/.terraform.versions directory if it doesn't exist
-		// https://gist.github.com/ivanzoid/5040166bb3f0c82575b52c2ca5f5a60c
-		if _, err := os.Stat(binaryDownloadDirectory); os.IsNotExist(err) {
-			os.Mkdir(binaryDownloadDirectory, os.ModeDir|0o755)
-		}
-
-		var binaryUrl string
-		binaryUrl, err := GetTerraformBinaryUrlE(version)
-		if err != nil {
-			return "", err
-		}
-
-		req := fmt.Sprintf(binaryUrl)
-
-		resp, err := http.Get(req)
-		if err != nil {
-			return "", err
-		}
-
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			fmt.Errorf("Error: non 200 response code")
-			return "", nil
-		}
-
-		// Create the file
-		out, err := os.Create(binaryDownloadDirectory + "/" + "terraform_" + version + "_binary.zip")
-		if err != nil {
-			fmt.Errorf("Error: %s", err)
-		}
-
-		// Write the body to file
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			fmt.Errorf("Error: %s", err)
-		}
-
-		// Sample code to extract zip file taken from https://stackoverflow.com/questions/20357223/easy-way-to-unzip-file-with-golang
-		r, err := zip.OpenReader(out.Name())
-		if err != nil {
-			return "", err
-		}
-
-		// Cleanup temp directories
-		defer func() {
-			if tempErr := r.Close(); tempErr != nil {
-				err = tempErr
-			}
-		}()
-		defer os.Remove(out.Name())
-
-		zipExtractPath := binaryDownloadDirectory + "/bin_" + version
-		os.MkdirAll(zipExtractPath, 0o755)
-
-		// Cleanup zip files
-		defer os.RemoveAll(zipExtractPath)
-
-		for _, f := range r.File {
-			err := extractAndWriteFile(zipExtractPath, f)
-			if err != nil {
-				return "", err
-			}
-		}
-
-		// Move file from temporarily extracted location
-		oldBinaryLocation := zipExtractPath + "/terraform"
-		err = os.Rename(oldBinaryLocation, binaryPath)
-		if err != nil {
-			return "", err
-		}
-	}
+	return DownloadTerraformVersionContextE(context.Background(), version)
+}
 
-	return binaryPath, nil
+// DownloadTerraformVersionContextE will download the specified version of Terraform into the
+// Terraform cache, stopping when ctx is done.
+//
+// Usage:
+// * ctx is the context of the download.
+// * version is the version of Terraform to download.
+func DownloadTerraformVersionContextE(ctx context.Context, version string) (binaryPath string, err error) {
+	return DownloadEngineVersionContextE(ctx, TerraformEngine(), version, CurrentPlatform())
 }
 
-// DownloadTerraformVersion will download the specified version of Terraform into the ~/.terraform.versions directory.
+// DownloadTerraformVersion will download the specified version of Terraform into the Terraform cache (~/.terraform.versions by default).
 //
 // Usage:
 // * version is the version of Terraform to download.
 func DownloadTerraformVersion(t *testing.T, version string) string {
-	binaryPath, err := DownloadTerraformVersionE(version)
+	binaryPath, err := DownloadTerraformVersionContextE(testContext(t), version)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	return binaryPath
@@ -1,69 +1,34 @@
 package testhelpers
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"os"
-	"regexp"
-	"slices"
-	"sort"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	teststructure "github.com/gruntwork-io/terratest/modules/test-structure"
-	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclwrite"
-	"github.com/mpvl/unique"
+	version "github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2/gohcl"
 )
 
-var blockedTerraformVersions = []string{"1.10.0"}
-
-// Version represents a semantic version
-type Version struct {
-	Major int
-	Minor int
-	Patch int
-}
-
-// ParseVersion converts a semantic version string to a Version struct
-func parseVersion(v string) (Version, error) {
-	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
-	if len(parts) < 3 {
-		return Version{}, fmt.Errorf("invalid semantic version format: %s", v)
-	}
-	major, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return Version{}, fmt.Errorf("invalid major version: %s", parts[0])
-	}
-	minor, err := strconv.Atoi(parts[1])
+// FilterMinorVersionsE groups versions by their major.minor version, returning the latest
+// patch release of each in ascending order and canonical form, or returns an error if an error
+// occurs during filtering
+func FilterMinorVersionsE(versions []string) ([]string, error) {
+	parsed, err := parseSortedVersions(versions)
 	if err != nil {
-		return Version{}, fmt.Errorf("invalid minor version: %s", parts[1])
+		return nil, err
 	}
 
-	return Version{
-		Major: major,
-		Minor: minor,
-		Patch: 0,
-	}, nil
-}
-
-// FilterMinorVersionsE groups versions by their major.minor version or
-// returns an error if an error occurs during filtering
-func FilterMinorVersionsE(versions []string) ([]string, error) {
 	var minorVersions []string
-	for _, v := range versions {
-		parsed, err := parseVersion(v)
-		if err != nil {
-			return nil, err
+	for i, v := range parsed {
+		if i+1 < len(parsed) && sameMinorVersion(v, parsed[i+1]) {
+			continue
 		}
-		minorKey := fmt.Sprintf("%d.%d.%d", parsed.Major, parsed.Minor, parsed.Patch)
-		minorVersions = append(minorVersions, minorKey)
+		minorVersions = append(minorVersions, v.String())
 	}
-	sort.Strings(minorVersions)
-	unique.Strings(&minorVersions)
 	return minorVersions, nil
 }
 
@@ -72,63 +37,197 @@ func FilterMinorVersionsE(versions []string) ([]string, error) {
 func FilterMinorVersions(t *testing.T, versions []string) []string {
 	versions, err := FilterMinorVersionsE(versions)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return versions
 }
 
-// GetTerraformVersionConstraintE returns the Terraform version string for the given module
-// or an error if the provider cannot be found
+// GetTerraformVersionConstraintE returns the Terraform version constraint for the given
+// module, or an error if it has none or its constraints conflict.
+//
+// The constraint combines the required_version settings of every terraform block in the
+// module, with those in override files replacing the rest as Terraform does, and the version
+// pinned in a .terraform-version or .tool-versions file in the module's directory or the
+// nearest parent directory up to the repository root. A pinned version that doesn't satisfy
+// required_version, or differs between the two files, is a conflict.
 func GetTerraformVersionConstraintE(srcDir string) (string, error) {
-	files, err := os.ReadDir(srcDir)
+	required, err := getRequiredVersionsE(srcDir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkRequiredVersionsE(required); err != nil {
+		return "", err
+	}
+
+	pin, err := getPinnedTerraformVersionE(srcDir)
 	if err != nil {
 		return "", err
 	}
 
-	vRegexp := regexp.MustCompile("required_version\\s*=\\s*\"([^\"]+)\"")
+	var constraints []string
+	for _, req := range required {
+		constraints = append(constraints, req.constraint)
+	}
+
+	if pin != nil {
+		v, err := version.NewVersion(pin.version)
+		if err != nil {
+			return "", err
+		}
 
-	for _, file := range files {
-		if file.IsDir() {
+		for _, req := range required {
+			c, err := version.NewConstraint(req.constraint)
+			if err != nil {
+				return "", fmt.Errorf("invalid required_version %q in %s: %w", req.constraint, req.filename, err)
+			}
+			if !c.Check(v) {
+				return "", fmt.Errorf("Terraform %s pinned in %s does not satisfy required_version %q in %s", pin.version, pin.filename, req.constraint, req.filename)
+			}
+		}
+
+		constraints = append(constraints, "= "+pin.version)
+	}
+
+	if len(constraints) == 0 {
+		return "", fmt.Errorf("required_version setting not found")
+	}
+
+	return strings.Join(constraints, ", "), nil
+}
+
+// versionSetting is a version constraint or pin and the file it was read from.
+type versionSetting struct {
+	constraint string
+	version    string
+	filename   string
+}
+
+// getRequiredVersionsE returns the required_version settings of the module's terraform
+// blocks, in .tf and .tf.json files. As in Terraform, the settings in override files replace
+// those in the other files, and a later override file replaces an earlier one.
+func getRequiredVersionsE(srcDir string) ([]versionSetting, error) {
+	files, diags, err := parseModuleFilesE(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var required, overrides []versionSetting
+	for _, f := range files {
+		var schema moduleFileSchema
+		declDiags := gohcl.DecodeBody(f.Body, nil, &schema)
+		diags = append(diags, declDiags...)
+		if declDiags.HasErrors() {
 			continue
 		}
 
-		if !strings.HasSuffix(file.Name(), ".tf") {
+		var settings []versionSetting
+		for _, terraform := range schema.Terraform {
+			if terraform.RequiredVersion != nil {
+				settings = append(settings, versionSetting{constraint: *terraform.RequiredVersion, filename: f.Name})
+			}
+		}
+
+		if f.Override {
+			if len(settings) > 0 {
+				overrides = settings
+			}
 			continue
 		}
+		required = append(required, settings...)
+	}
 
-		filename := fmt.Sprintf("%s/%s", srcDir, file.Name())
-		content, err := os.ReadFile(filename)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if overrides != nil {
+		return overrides, nil
+	}
+	return required, nil
+}
+
+// checkRequiredVersionsE returns an error if a required_version setting is invalid, or if no
+// version satisfies all of them.
+func checkRequiredVersionsE(required []versionSetting) error {
+	constraints := make([]version.Constraints, 0, len(required))
+	for _, req := range required {
+		c, err := version.NewConstraint(req.constraint)
 		if err != nil {
-			return "", err
+			return fmt.Errorf("invalid required_version %q in %s: %w", req.constraint, req.filename, err)
 		}
+		constraints = append(constraints, c)
+	}
 
-		f, diag := hclwrite.ParseConfig(content, file.Name(), hcl.Pos{Line: 1, Column: 1})
-		if diag.HasErrors() {
-			return "", errors.New(diag.Error())
+	for i := range required {
+		for j := i + 1; j < len(required); j++ {
+			if !haveCommonVersion(constraints[i], constraints[j]) {
+				return fmt.Errorf("required_version %q in %s and %q in %s have no version in common", required[i].constraint, required[i].filename, required[j].constraint, required[j].filename)
+			}
 		}
+	}
 
-		for _, block := range f.Body().Blocks() {
-			if block.Type() != "terraform" {
-				continue
-			}
+	if !haveCommonVersion(constraints...) {
+		var settings []string
+		for _, req := range required {
+			settings = append(settings, fmt.Sprintf("%q in %s", req.constraint, req.filename))
+		}
+		return fmt.Errorf("required_version %s have no version in common", strings.Join(settings, ", "))
+	}
 
-			requiredVersionSetting := block.Body().GetAttribute("required_version")
-			if requiredVersionSetting == nil {
+	return nil
+}
+
+// haveCommonVersion reports whether a version satisfies every one of the constraints. The
+// lowest such version is either 0.0.0, a version the constraints name or the patch release
+// after one, followed by at most as many patch releases as there are versions they exclude,
+// so only those are checked.
+func haveCommonVersion(constraints ...version.Constraints) bool {
+	var bounds []*version.Version
+	for _, c := range constraints {
+		for _, part := range strings.Split(c.String(), ",") {
+			v, err := version.NewVersion(strings.TrimLeft(strings.TrimSpace(part), "=!<>~ "))
+			if err != nil {
 				continue
 			}
+			bounds = append(bounds, v)
+		}
+	}
 
-			val := requiredVersionSetting.BuildTokens(nil).Bytes()
-			constraint := vRegexp.FindSubmatch(val)
+	candidates := []*version.Version{version.Must(version.NewVersion("0.0.0"))}
+	for _, v := range bounds {
+		candidates = append(candidates, v, nextPatchVersion(v))
+	}
 
-			if constraint == nil || len(constraint) < 2 {
-				continue
+	for _, candidate := range candidates {
+		v := candidate
+		for i := 0; i <= len(bounds); i++ {
+			matches := true
+			for _, c := range constraints {
+				if !c.Check(v) {
+					matches = false
+					break
+				}
 			}
-
-			return string(constraint[1]), nil
+			if matches {
+				return true
+			}
+			v = nextPatchVersion(v)
 		}
 	}
 
-	return "", fmt.Errorf("required_version setting not found")
+	return false
+}
+
+// nextPatchVersion returns the patch release after the version's major.minor.patch release.
+func nextPatchVersion(v *version.Version) *version.Version {
+	segments := v.Segments()
+	return version.Must(version.NewVersion(fmt.Sprintf("%d.%d.%d", segments[0], segments[1], segments[2]+1)))
+}
+
+// isOverrideFile reports whether Terraform treats the file as an override file.
+func isOverrideFile(name string) bool {
+	return name == "override.tf" || strings.HasSuffix(name, "_override.tf")
 }
 
 // GetTerraformVersionConstraint returns the Terraform version string for the given module
@@ -136,7 +235,7 @@ func GetTerraformVersionConstraintE(srcDir string) (string, error) {
 func GetTerraformVersionConstraint(t *testing.T, srcDir string) string {
 	constraint, err := GetTerraformVersionConstraintE(srcDir)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	return constraint
 }
@@ -158,179 +257,193 @@ func newTerraformOptions(t *testing.T) *terraform.Options {
 	return opts
 }
 
-func filterBlockedTerraformVersion(available []string) []string {
-	slices.Sort(available)
-	var filteredAvailableVersions []string
+// TerraformVersionsTest runs InitAndPlan against every released version of Terraform that
+// satisfies the module's required_version constraint.
+func TerraformVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
+	TerraformVersionsTestWithOptions(t, srcDir, newMatrixOptionsFromMaps(t, variables, environment_variables))
+}
 
-	for _, blockedVersion := range blockedTerraformVersions {
-		n, found := slices.BinarySearch(available, blockedVersion)
-		if found {
-			filteredAvailableVersions = slices.Delete(available, n, n+1)
+// TerraformVersionsTestWithOptions runs InitAndPlan against every released version of Terraform
+// that satisfies the module's required_version constraint, using opts as the template for
+// each subtest's terraform.Options. Set Engines on opts to test the versions of other engines,
+// such as OpenTofu, that satisfy the constraint too. Versions in the Blocklist are reported as
+// skipped subtests.
+func TerraformVersionsTestWithOptions(t *testing.T, srcDir string, opts *MatrixOptions) {
+	opts.runEngines(t, func(t *testing.T, engine Engine) {
+		matching := getEngineMatrixVersions(t, srcDir, engine)
+		skipBlockedVersions(t, "", matching.release, matching.blocked)
+		versions := opts.selectVersions(t, matching.versions, matching.releases)
+
+		for _, version := range versions {
+			version := version
+			t.Run(version, func(t *testing.T) {
+				t.Parallel()
+
+				tfOptions := opts.cloneTerraformOptions(t)
+				dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, "")
+				UpdateModuleSourcesToLocalPaths(t, dst)
+				binaryPath := DownloadEngineVersion(t, engine, version)
+				tfOptions.TerraformDir = dst
+				tfOptions.TerraformBinary = binaryPath
+				terraform.InitAndPlan(t, tfOptions)
+			})
 		}
-	}
-	return filteredAvailableVersions
+	})
 }
 
-func TerraformVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	constraint := GetTerraformVersionConstraint(t, srcDir)
-	available := GetAvailableVersions(t, "terraform")
-	filteredAvailable := filterBlockedTerraformVersion(available)
-	versions := GetMatchingVersions(t, constraint, filteredAvailable)
-
-	for _, version := range versions {
-		tfOptions := newTerraformOptions(t)
-
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
-		version := version
-		t.Run(version, func(t *testing.T) {
-			t.Parallel()
+// ProviderVersionsTest runs InitAndPlan against every released version of the given provider
+// that satisfies the constraint declared in the module's required_providers block. The provider
+// is looked up by its local name, and its source address is read from the module so any provider
+// can be tested.
+func ProviderVersionsTest(t *testing.T, srcDir, provider string, variables map[string]interface{}, environment_variables map[string]string) {
+	ProviderVersionsTestWithOptions(t, srcDir, provider, newMatrixOptionsFromMaps(t, variables, environment_variables))
+}
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, "")
-			UpdateModuleSourcesToLocalPaths(t, dst)
-			binaryPath := DownloadTerraformVersion(t, version)
-			tfOptions.TerraformDir = dst
-			tfOptions.TerraformBinary = binaryPath
-			terraform.InitAndPlan(t, tfOptions)
-		})
-	}
+// ProviderVersionsTestWithOptions runs InitAndPlan against every released version of the given
+// provider that satisfies the module's constraint, using opts as the template for each
+// subtest's terraform.Options. Versions in the Blocklist are reported as skipped subtests.
+func ProviderVersionsTestWithOptions(t *testing.T, srcDir, provider string, opts *MatrixOptions) {
+	matching := getProviderMatrixVersions(t, srcDir, provider, TerraformEngine())
+	skipBlockedVersions(t, "", matching.release, matching.blocked)
+	providerVersionsTest(t, srcDir, provider, matching.release, opts.selectVersions(t, matching.versions, matching.releases), opts)
 }
 
+// AwsProviderVersionsTest runs ProviderVersionsTest for the aws provider.
+//
+// Deprecated: use ProviderVersionsTest with the "aws" local name instead.
 func AwsProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	constraint := GetProviderConstraint(t, srcDir, "aws")
-	available := GetAvailableVersions(t, "terraform-provider-aws")
-	versions := GetMatchingVersions(t, constraint, available)
-
-	for _, version := range versions {
-		tfOptions := newTerraformOptions(t)
-
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
+	ProviderVersionsTest(t, srcDir, "aws", variables, environment_variables)
+}
 
-		version := version
-		t.Run(version, func(t *testing.T) {
-			t.Parallel()
+// CloudflareProviderVersionsTest runs ProviderVersionsTest for the cloudflare provider.
+//
+// Deprecated: use ProviderVersionsTest with the "cloudflare" local name instead.
+func CloudflareProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
+	ProviderVersionsTest(t, srcDir, "cloudflare", variables, environment_variables)
+}
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, "")
-			UpdateModuleSourcesToLocalPaths(t, dst)
-			UpdateProviderVersion(t, dst, "aws", version, "hashicorp/aws")
-			tfOptions.TerraformDir = dst
-			terraform.InitAndPlan(t, tfOptions)
-		})
-	}
+// DatadogProviderVersionsTest runs ProviderVersionsTest for the datadog provider.
+//
+// Deprecated: use ProviderVersionsTest with the "datadog" local name instead.
+func DatadogProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
+	ProviderVersionsTest(t, srcDir, "datadog", variables, environment_variables)
 }
 
-func CloudflareProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	constraint := GetProviderConstraint(t, srcDir, "cloudflare")
-	available := GetAvailableVersions(t, "terraform-provider-cloudflare")
-	testVers := GetMatchingVersions(t, constraint, available)
+// OpsgenieProviderVersionsTest runs ProviderVersionsTest for the opsgenie provider.
+//
+// Deprecated: use ProviderVersionsTest with the "opsgenie" local name instead.
+func OpsgenieProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
+	ProviderVersionsTest(t, srcDir, "opsgenie", variables, environment_variables)
+}
 
-	for _, version := range testVers {
-		tfOptions := newTerraformOptions(t)
+// GcpProviderVersionsTest runs ProviderVersionsTest for the google provider.
+//
+// Deprecated: use ProviderVersionsTest with the "google" local name instead.
+func GcpProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
+	ProviderVersionsTest(t, srcDir, "google", variables, environment_variables)
+}
 
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
+func providerVersionsTest(t *testing.T, srcDir, provider, source string, versions []string, opts *MatrixOptions) {
+	for _, version := range versions {
 		version := version
 		t.Run(version, func(t *testing.T) {
 			t.Parallel()
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, ".")
+			tfOptions := opts.cloneTerraformOptions(t)
+			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, "")
 			UpdateModuleSourcesToLocalPaths(t, dst)
-			UpdateProviderVersion(t, dst, "cloudflare", version, "cloudflare/cloudflare")
+			UpdateProviderVersion(t, dst, provider, version, source)
+			sources, pins := map[string]string{provider: source}, map[string]string{provider: version}
+			opts.lockProviders(t, dst, sources, pins)
+			opts.configureProviderInstallation(t, tfOptions, sources, pins)
 			tfOptions.TerraformDir = dst
 			terraform.InitAndPlan(t, tfOptions)
 		})
 	}
 }
 
-func DatadogProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	constraint := GetProviderConstraint(t, "..", "datadog")
-	available := GetAvailableVersions(t, "terraform-provider-datadog")
-	testVers := GetMatchingVersions(t, constraint, available)
+// matrixVersions are the released versions of an engine or provider matching a module's
+// constraint.
+type matrixVersions struct {
+	// release is the engine's release name or the provider's source address.
+	release string
+	// versions are the matching versions that may be tested.
+	versions []string
+	// releases holds the metadata of the versions, keyed by their normalised version.
+	releases map[string]ReleaseMetadata
+	// blocked are the matching versions in the Blocklist, with the entry blocking each.
+	blocked map[string]BlockedVersion
+}
 
-	for _, version := range testVers {
-		tfOptions := newTerraformOptions(t)
+// newMatrixVersionsE returns the versions of the releases matching the constraint, with those
+// in the Blocklist split out.
+func newMatrixVersionsE(release, constraint string, available []ReleaseMetadata) (*matrixVersions, error) {
+	matching, err := GetMatchingVersionsE(constraint, releaseVersions(available))
+	if err != nil {
+		return nil, err
+	}
 
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
-		version := version
-		t.Run(version, func(t *testing.T) {
-			t.Parallel()
+	versions, blocked := filterBlockedVersions(release, matching)
+	return &matrixVersions{
+		release:  release,
+		versions: versions,
+		releases: releasesByVersion(available),
+		blocked:  blocked,
+	}, nil
+}
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, ".")
-			UpdateModuleSourcesToLocalPaths(t, dst)
-			UpdateProviderVersion(t, dst, "datadog", version, "datadog/datadog")
-			tfOptions.TerraformDir = dst
-			terraform.InitAndPlan(t, tfOptions)
-		})
+// getEngineMatrixVersions returns the versions of the engine matching the module's
+// required_version constraint, with the matching versions in the Blocklist split out.
+func getEngineMatrixVersions(t *testing.T, srcDir string, engine Engine) *matrixVersions {
+	matching, err := getEngineMatrixVersionsE(testContext(t), srcDir, engine)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return matching
 }
 
-func OpsgenieProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	// Raised issue with OpsGenie https://github.com/opsgenie/terraform-provider-opsgenie/issues/367
-	testVers := []string{"0.6.10", "0.6.11", "0.6.14", "0.6.15", "0.6.16", "0.6.17", "0.6.18", "0.6.19", "0.6.20"} // testing for specific versions as https://api.releases.hashicorp.com/v1/releases/terraform-provider-opsgenie is not showing anything newer than 0.6.11 currently
+func getEngineMatrixVersionsE(ctx context.Context, srcDir string, engine Engine) (*matrixVersions, error) {
+	constraint, err := GetTerraformVersionConstraintE(srcDir)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, version := range testVers {
-		tfOptions := newTerraformOptions(t)
+	available, err := GetAvailableEngineReleasesContextE(ctx, engine)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
-		version := version
-		t.Run(version, func(t *testing.T) {
-			t.Parallel()
+	return newMatrixVersionsE(engine.Release, constraint, available)
+}
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, ".")
-			UpdateModuleSourcesToLocalPaths(t, dst)
-			UpdateProviderVersion(t, dst, "opsgenie", version, "opsgenie/opsgenie")
-			tfOptions.TerraformDir = dst
-			terraform.InitAndPlan(t, tfOptions)
-		})
+// getProviderMatrixVersions returns the released versions of the provider matching its
+// constraint in the module, as published to the registry the engine installs it from, with the
+// matching versions in the Blocklist split out. The release of the result is the provider's
+// source address.
+func getProviderMatrixVersions(t *testing.T, srcDir, provider string, engine Engine) *matrixVersions {
+	matching, err := getProviderMatrixVersionsE(testContext(t), srcDir, provider, engine)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return matching
 }
 
-func GcpProviderVersionsTest(t *testing.T, srcDir string, variables map[string]interface{}, environment_variables map[string]string) {
-	constraint := GetProviderConstraint(t, "..", "google")
-	available := GetAvailableVersions(t, "terraform-provider-google")
-	testVers := GetMatchingVersions(t, constraint, available)
-
-	for _, version := range testVers {
-		tfOptions := newTerraformOptions(t)
+func getProviderMatrixVersionsE(ctx context.Context, srcDir, provider string, engine Engine) (*matrixVersions, error) {
+	constraint, err := GetProviderConstraintE(srcDir, provider)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(variables) > 0 {
-			tfOptions.Vars = variables
-		}
-		if len(environment_variables) > 0 {
-			tfOptions.EnvVars = environment_variables
-		}
-		version := version
-		t.Run(version, func(t *testing.T) {
-			t.Parallel()
+	p, err := GetRequiredProviderE(srcDir, provider)
+	if err != nil {
+		return nil, err
+	}
 
-			dst := teststructure.CopyTerraformFolderToTemp(t, srcDir, ".")
-			UpdateModuleSourcesToLocalPaths(t, dst)
-			UpdateProviderVersion(t, dst, "google", version, "hashicorp/google")
-			tfOptions.TerraformDir = dst
-			terraform.InitAndPlan(t, tfOptions)
-		})
+	source := engine.qualifyProviderSource(p.SourceAddress())
+	available, err := GetAvailableProviderVersionsContextE(ctx, source)
+	if err != nil {
+		return nil, err
 	}
+
+	return newMatrixVersionsE(source, constraint, releasesFromVersions(available))
 }
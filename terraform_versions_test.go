@@ -0,0 +1,205 @@
+package testhelpers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestModule writes the files of a module into a temporary directory, which is made the
+// root of a repository so that nothing outside it, such as a version pin, affects the test.
+func writeTestModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGetTerraformVersionConstraint(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "single block",
+			files: map[string]string{"versions.tf": `terraform { required_version = ">= 1.3.0" }`},
+			want:  ">= 1.3.0",
+		},
+		{
+			name: "several blocks",
+			files: map[string]string{
+				"a.tf": `terraform { required_version = ">= 1.3.0" }`,
+				"b.tf": "terraform {\n  required_version = \"< 2.0.0\"\n}\nterraform {\n  required_version = \"!= 1.5.0\"\n}\n",
+			},
+			want: ">= 1.3.0, < 2.0.0, != 1.5.0",
+		},
+		{
+			name: "json",
+			files: map[string]string{
+				"a.tf":         `terraform { required_version = ">= 1.3.0" }`,
+				"main.tf.json": `{"terraform": {"required_version": "~> 1.5.0"}}`,
+			},
+			want: ">= 1.3.0, ~> 1.5.0",
+		},
+		{
+			name: "override",
+			files: map[string]string{
+				"a.tf":          `terraform { required_version = ">= 1.3.0" }`,
+				"b.tf":          `terraform { required_version = "< 1.4.0" }`,
+				"b_override.tf": `terraform { required_version = "~> 1.6.0" }`,
+			},
+			want: "~> 1.6.0",
+		},
+		{
+			name: "later override file wins",
+			files: map[string]string{
+				"a.tf":               `terraform { required_version = ">= 1.3.0" }`,
+				"a_override.tf":      `terraform { required_version = "~> 1.5.0" }`,
+				"b_override.tf.json": `{"terraform": {"required_version": "~> 1.6.0"}}`,
+			},
+			want: "~> 1.6.0",
+		},
+		{
+			name: "override without required_version",
+			files: map[string]string{
+				"a.tf":        `terraform { required_version = ">= 1.3.0" }`,
+				"override.tf": `terraform { experiments = [] }`,
+			},
+			want: ">= 1.3.0",
+		},
+		{
+			name: "conflict",
+			files: map[string]string{
+				"a.tf": `terraform { required_version = "~> 1.3.0" }`,
+				"b.tf": `terraform { required_version = ">= 1.5.0" }`,
+			},
+			wantErr: `required_version "~> 1.3.0" in a.tf and ">= 1.5.0" in b.tf have no version in common`,
+		},
+		{
+			name: "conflict between three blocks",
+			files: map[string]string{
+				"a.tf": `terraform { required_version = ">= 1.3.0, < 1.3.2" }`,
+				"b.tf": `terraform { required_version = "!= 1.3.0" }`,
+				"c.tf": `terraform { required_version = "!= 1.3.1" }`,
+			},
+			wantErr: "have no version in common",
+		},
+		{
+			name: "excluded versions leave a gap",
+			files: map[string]string{
+				"a.tf": `terraform { required_version = "> 1.3.0, < 1.3.4" }`,
+				"b.tf": `terraform { required_version = "!= 1.3.1, != 1.3.2" }`,
+			},
+			want: "> 1.3.0, < 1.3.4, != 1.3.1, != 1.3.2",
+		},
+		{
+			name:    "not a string",
+			files:   map[string]string{"a.tf": `terraform { required_version = [">= 1.3.0"] }`},
+			wantErr: "a.tf:1,",
+		},
+		{
+			name:    "invalid constraint",
+			files:   map[string]string{"a.tf": `terraform { required_version = "latest" }`},
+			wantErr: `invalid required_version "latest" in a.tf`,
+		},
+		{
+			name:    "missing",
+			files:   map[string]string{"a.tf": `terraform {}`},
+			wantErr: "required_version setting not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetTerraformVersionConstraintE(writeTestModule(t, tt.files))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetPinnedTerraformVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		srcDir string
+		want   string
+	}{
+		{
+			name:   "module directory",
+			files:  map[string]string{"module/.terraform-version": "1.5.7\n"},
+			srcDir: "module",
+			want:   "1.5.7",
+		},
+		{
+			name:   "repository root",
+			files:  map[string]string{".git/HEAD": "", ".terraform-version": "1.5.7\n"},
+			srcDir: "module",
+			want:   "1.5.7",
+		},
+		{
+			name:   "outside the repository",
+			files:  map[string]string{".terraform-version": "1.5.7\n", "repo/.git/HEAD": ""},
+			srcDir: "repo/module",
+		},
+		{
+			name:   "no repository",
+			files:  map[string]string{".terraform-version": "1.5.7\n", ".tool-versions": "terraform 1.5.7\n"},
+			srcDir: "module",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range tt.files {
+				filename := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			srcDir := filepath.Join(root, filepath.FromSlash(tt.srcDir))
+			if err := os.MkdirAll(srcDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			pin, err := getPinnedTerraformVersionE(srcDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			if pin != nil {
+				got = pin.version
+			}
+			if got != tt.want {
+				t.Errorf("expected the pinned version %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
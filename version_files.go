@@ -0,0 +1,164 @@
+package testhelpers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+)
+
+const (
+	// terraformVersionFile is the file tfenv reads the Terraform version to use from.
+	terraformVersionFile = ".terraform-version"
+	// toolVersionsFile is the file asdf reads the versions of each tool to use from.
+	toolVersionsFile = ".tool-versions"
+)
+
+// getPinnedTerraformVersionE returns the Terraform version pinned for the module by a
+// .terraform-version or .tool-versions file, or nil if neither pins one. Like tfenv and asdf,
+// the nearest file in the module's directory or its parents is used, stopping at the root of
+// the repository. Only the module's own directory is searched when it isn't in a repository,
+// so that files elsewhere on the machine, such as in the home directory, are never used.
+// Entries that aren't a version, such as "latest" or "system", don't pin one.
+func getPinnedTerraformVersionE(srcDir string) (*versionSetting, error) {
+	dir, err := filepath.Abs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := findRepositoryRoot(dir)
+	if boundary == "" {
+		boundary = dir
+	}
+
+	var tfenvPin, asdfPin *versionSetting
+	var tfenvFound, asdfFound bool
+	for {
+		if !tfenvFound {
+			filename := filepath.Join(dir, terraformVersionFile)
+			content, err := readFileIfExists(filename)
+			if err != nil {
+				return nil, err
+			}
+			if content != nil {
+				tfenvFound = true
+				tfenvPin = parseTerraformVersionFile(filename, content)
+			}
+		}
+
+		if !asdfFound {
+			filename := filepath.Join(dir, toolVersionsFile)
+			content, err := readFileIfExists(filename)
+			if err != nil {
+				return nil, err
+			}
+			if content != nil {
+				// Only a .tool-versions file listing terraform stops the search, as asdf
+				// looks further up for tools a nearer file doesn't list.
+				asdfPin, asdfFound = parseToolVersionsFile(filename, content)
+			}
+		}
+
+		if (tfenvFound && asdfFound) || dir == boundary {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	switch {
+	case tfenvPin == nil:
+		return asdfPin, nil
+	case asdfPin == nil:
+		return tfenvPin, nil
+	case tfenvPin.version != asdfPin.version:
+		return nil, fmt.Errorf("Terraform %s pinned in %s conflicts with Terraform %s pinned in %s", tfenvPin.version, tfenvPin.filename, asdfPin.version, asdfPin.filename)
+	default:
+		return tfenvPin, nil
+	}
+}
+
+// parseTerraformVersionFile returns the version pinned by a .terraform-version file, which
+// holds the version on its first line.
+func parseTerraformVersionFile(filename string, content []byte) *versionSetting {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return newVersionPin(filename, line)
+	}
+	return nil
+}
+
+// parseToolVersionsFile returns the version pinned by the terraform line of a .tool-versions
+// file, which lists a tool and its versions on each line, and whether the file has such a line.
+// The first version listed is the one asdf prefers.
+func parseToolVersionsFile(filename string, content []byte) (*versionSetting, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "terraform" {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, true
+		}
+		return newVersionPin(filename, fields[1]), true
+	}
+	return nil, false
+}
+
+// newVersionPin returns the pin read from the file, or nil if the value isn't a version.
+func newVersionPin(filename, value string) *versionSetting {
+	if _, err := version.NewVersion(value); err != nil {
+		return nil
+	}
+	return &versionSetting{version: normaliseVersion(value), filename: filename}
+}
+
+// readFileIfExists returns the contents of the file, or nil if it doesn't exist.
+func readFileIfExists(filename string) ([]byte, error) {
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if content == nil && err == nil {
+		content = []byte{}
+	}
+	return content, err
+}
+
+// findRepositoryRoot returns the root of the repository dir is in, or an empty string if it
+// isn't in one.
+func findRepositoryRoot(dir string) string {
+	for {
+		if isRepositoryRoot(dir) {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isRepositoryRoot reports whether the directory is the root of a git repository.
+func isRepositoryRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
@@ -0,0 +1,257 @@
+package testhelpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// VersionCacheTTLEnvVar is the environment variable that sets how long cached version
+	// listings are used for, as a duration such as "30m".
+	VersionCacheTTLEnvVar = "TERRAFORM_TESTING_VERSION_CACHE_TTL"
+	// OfflineEnvVar is the environment variable that turns on offline mode when set to true.
+	OfflineEnvVar = "TERRAFORM_TESTING_OFFLINE"
+)
+
+// DefaultVersionCacheTTL is how long cached version listings are used for unless
+// SetVersionCacheTTL has been called or TERRAFORM_TESTING_VERSION_CACHE_TTL is set.
+const DefaultVersionCacheTTL = time.Hour
+
+var (
+	versionCacheMx  sync.RWMutex
+	versionCacheTTL *time.Duration
+	offlineMode     *bool
+
+	// versionListGroup deduplicates concurrent listings of the same release within the process.
+	versionListGroup singleflight.Group
+)
+
+// SetVersionCacheTTL changes how long the versions listed for a release are cached on disk
+// before they are listed again. Use zero to stop caching listings.
+func SetVersionCacheTTL(ttl time.Duration) {
+	versionCacheMx.Lock()
+	defer versionCacheMx.Unlock()
+
+	versionCacheTTL = &ttl
+}
+
+// GetVersionCacheTTL returns how long the versions listed for a release are cached for.
+func GetVersionCacheTTL() time.Duration {
+	versionCacheMx.RLock()
+	defer versionCacheMx.RUnlock()
+
+	if versionCacheTTL != nil {
+		return *versionCacheTTL
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv(VersionCacheTTLEnvVar)); err == nil {
+		return ttl
+	}
+
+	return DefaultVersionCacheTTL
+}
+
+// SetOfflineMode turns offline mode on or off. In offline mode, cached version listings are
+// used however old they are, so that repeated runs work without a network connection once
+// every release has been listed. Releases that have never been listed are still listed over
+// the network.
+func SetOfflineMode(offline bool) {
+	versionCacheMx.Lock()
+	defer versionCacheMx.Unlock()
+
+	offlineMode = &offline
+}
+
+// GetOfflineMode reports whether offline mode is on, either with SetOfflineMode or the
+// TERRAFORM_TESTING_OFFLINE environment variable.
+func GetOfflineMode() bool {
+	versionCacheMx.RLock()
+	defer versionCacheMx.RUnlock()
+
+	if offlineMode != nil {
+		return *offlineMode
+	}
+
+	offline, _ := strconv.ParseBool(os.Getenv(OfflineEnvVar))
+	return offline
+}
+
+// cacheableReleaseSource is implemented by the release sources that list versions over the
+// network, whose listings are worth caching on disk.
+type cacheableReleaseSource interface {
+	ReleaseSource
+	// versionCacheKey identifies where the source lists its versions from, so that listings
+	// from different sources of the same release are cached separately.
+	versionCacheKey() string
+}
+
+func (s *HashicorpReleaseSource) versionCacheKey() string {
+	return s.BaseURL
+}
+
+func (s *RegistryReleaseSource) versionCacheKey() string {
+	return s.BaseURL
+}
+
+func (s *OpenTofuReleaseSource) versionCacheKey() string {
+	return s.APIURL
+}
+
+// versionListing is a release's versions as cached on disk.
+type versionListing struct {
+	Source   string            `json:"source"`
+	Release  string            `json:"release"`
+	ListedAt time.Time         `json:"listed_at"`
+	Versions []string          `json:"versions"`
+	Releases []ReleaseMetadata `json:"releases,omitempty"`
+}
+
+// releases returns the releases in the listing.
+func (l *versionListing) releases() []ReleaseMetadata {
+	if len(l.Releases) == 0 {
+		// Listings cached before release metadata was recorded only hold the versions.
+		return releasesFromVersions(l.Versions)
+	}
+	return l.Releases
+}
+
+// listVersionsE lists the versions of the release from the source in ascending semver order,
+// following the prerelease policy.
+func listVersionsE(ctx context.Context, src ReleaseSource, release string) ([]string, error) {
+	releases, err := listReleasesE(ctx, src, release)
+	if err != nil {
+		return nil, err
+	}
+
+	return releaseVersions(releases), nil
+}
+
+// listReleasesE lists the releases from the source in ascending semver order, following the
+// prerelease policy.
+func listReleasesE(ctx context.Context, src ReleaseSource, release string) ([]ReleaseMetadata, error) {
+	releases, err := listCachedReleasesE(ctx, src, release)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyPrereleasePolicy(releases)
+}
+
+// listCachedReleasesE lists the releases from the source, using the listing cached in the
+// Cache's VersionsDir while it is younger than the version cache TTL, or at any age in offline
+// mode or when the source cannot be reached. Listings from sources that don't list over the
+// network aren't cached.
+func listCachedReleasesE(ctx context.Context, src ReleaseSource, release string) ([]ReleaseMetadata, error) {
+	cacheable, ok := src.(cacheableReleaseSource)
+	dir := GetCache().VersionsDir
+	ttl := GetVersionCacheTTL()
+	if !ok || dir == "" || ttl <= 0 {
+		return listSourceReleasesE(ctx, src, release)
+	}
+
+	key := cacheable.versionCacheKey()
+	filename := versionListingPath(dir, key, release)
+
+	cached, err := readVersionListingE(filename)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && (GetOfflineMode() || time.Since(cached.ListedAt) < ttl) {
+		return cached.releases(), nil
+	}
+
+	releases, err, _ := versionListGroup.Do(filename, func() (interface{}, error) {
+		releases, err := listSourceReleasesE(ctx, src, release)
+		if err != nil {
+			return nil, err
+		}
+
+		listing := &versionListing{
+			Source:   key,
+			Release:  release,
+			ListedAt: time.Now().UTC(),
+			Versions: releaseVersions(releases),
+			Releases: releases,
+		}
+		if err := writeVersionListingE(filename, listing); err != nil {
+			return nil, err
+		}
+
+		return releases, nil
+	})
+	if err != nil {
+		// A stale listing is better than none while the source is unreachable or failing.
+		if cached != nil && ctx.Err() == nil && isRetryableError(err) {
+			return cached.releases(), nil
+		}
+		return nil, err
+	}
+
+	return releases.([]ReleaseMetadata), nil
+}
+
+// versionListingPath returns where the listing of the release from the source is cached.
+func versionListingPath(dir, key, release string) string {
+	sum := sha256.Sum256([]byte(key + "\n" + release))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// readVersionListingE returns the listing cached in the file, or nil if there isn't one or it
+// cannot be parsed.
+func readVersionListingE(filename string) (*versionListing, error) {
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var listing versionListing
+	if err := json.Unmarshal(content, &listing); err != nil {
+		// A listing that cannot be read is listed again and replaced.
+		return nil, nil
+	}
+
+	return &listing, nil
+}
+
+// writeVersionListingE writes the listing to the file, replacing it in one step so that
+// concurrent readers never see part of it.
+func writeVersionListingE(filename string, listing *versionListing) error {
+	content, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,83 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListCachedReleasesFallsBackToStaleListing(t *testing.T) {
+	useFastRetries(t)
+
+	previousTTL := GetVersionCacheTTL()
+	t.Cleanup(func() { SetVersionCacheTTL(previousTTL) })
+	SetVersionCacheTTL(time.Hour)
+
+	tests := []struct {
+		status   int
+		fallback bool
+	}{
+		{status: http.StatusBadGateway, fallback: true},
+		{status: http.StatusTooManyRequests, fallback: true},
+		{status: http.StatusNotFound, fallback: false},
+		{status: http.StatusForbidden, fallback: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			cache := useTestCache(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "something went wrong", tt.status)
+			}))
+			defer server.Close()
+
+			src := &HashicorpReleaseSource{BaseURL: server.URL}
+			stale := &versionListing{
+				Source:   src.versionCacheKey(),
+				Release:  "terraform",
+				ListedAt: time.Now().Add(-2 * time.Hour).UTC(),
+				Versions: []string{"1.5.7"},
+			}
+			if err := writeVersionListingE(versionListingPath(cache.VersionsDir, stale.Source, "terraform"), stale); err != nil {
+				t.Fatal(err)
+			}
+
+			releases, err := listCachedReleasesE(context.Background(), src, "terraform")
+			if !tt.fallback {
+				var httpErr *HTTPError
+				if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.status {
+					t.Fatalf("expected an HTTPError with status %d, got %v", tt.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []ReleaseMetadata{{Version: "1.5.7"}}; !reflect.DeepEqual(releases, want) {
+				t.Errorf("expected the stale listing %v, got %v", want, releases)
+			}
+		})
+	}
+}
+
+func TestListCachedReleasesWithoutListing(t *testing.T) {
+	useFastRetries(t)
+	useTestCache(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "something went wrong", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := listCachedReleasesE(context.Background(), &HashicorpReleaseSource{BaseURL: server.URL}, "terraform")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected an HTTPError, got %v", err)
+	}
+}
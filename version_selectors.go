@@ -0,0 +1,312 @@
+package testhelpers
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// VersionSelector chooses which of the versions matching a constraint a matrix test should
+// run against, so that large matrices can be cut down to a manageable number of plans.
+type VersionSelector interface {
+	// SelectVersions returns the versions to test from the given list of matching versions.
+	SelectVersions(versions []string) ([]string, error)
+}
+
+// VersionSelectorFunc adapts an ordinary function to the VersionSelector interface.
+type VersionSelectorFunc func(versions []string) ([]string, error)
+
+// SelectVersions calls f(versions).
+func (f VersionSelectorFunc) SelectVersions(versions []string) ([]string, error) {
+	return f(versions)
+}
+
+// SelectAllVersions returns a VersionSelector that tests every matching version.
+func SelectAllVersions() VersionSelector {
+	return VersionSelectorFunc(sortVersionStrings)
+}
+
+// SelectLatestPatchVersions returns a VersionSelector that tests the latest patch release of
+// every major.minor version.
+func SelectLatestPatchVersions() VersionSelector {
+	return VersionSelectorFunc(FilterMinorVersionsE)
+}
+
+// SelectBoundaryVersions returns a VersionSelector that tests only the lowest and highest
+// matching versions.
+func SelectBoundaryVersions() VersionSelector {
+	return VersionSelectorFunc(func(versions []string) ([]string, error) {
+		sorted, err := sortVersionStrings(versions)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sorted) <= 2 {
+			return sorted, nil
+		}
+
+		return []string{sorted[0], sorted[len(sorted)-1]}, nil
+	})
+}
+
+// SelectNewestVersions returns a VersionSelector that tests the n newest matching versions.
+func SelectNewestVersions(n int) VersionSelector {
+	return VersionSelectorFunc(func(versions []string) ([]string, error) {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid number of versions to select: %d", n)
+		}
+
+		sorted, err := sortVersionStrings(versions)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sorted) <= n {
+			return sorted, nil
+		}
+
+		return sorted[len(sorted)-n:], nil
+	})
+}
+
+// SelectRandomVersions returns a VersionSelector that tests a random sample of n matching
+// versions. The sample is chosen with the given seed, so the same seed and versions always
+// produce the same selection regardless of the order the versions were listed in.
+func SelectRandomVersions(n int, seed int64) VersionSelector {
+	return VersionSelectorFunc(func(versions []string) ([]string, error) {
+		if n < 0 {
+			return nil, fmt.Errorf("invalid number of versions to select: %d", n)
+		}
+
+		sorted, err := sortVersionStrings(versions)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sorted) <= n {
+			return sorted, nil
+		}
+
+		rnd := rand.New(rand.NewSource(seed))
+		picked := rnd.Perm(len(sorted))[:n]
+		sort.Ints(picked)
+
+		sample := make([]string, 0, n)
+		for _, i := range picked {
+			sample = append(sample, sorted[i])
+		}
+		return sample, nil
+	})
+}
+
+// ReleaseSelector is a VersionSelector that chooses versions by their release metadata, such
+// as when they were released. Given only versions, it selects from releases with no known
+// release date.
+type ReleaseSelector interface {
+	VersionSelector
+	// SelectReleases returns the releases to test from the given list of matching releases.
+	SelectReleases(releases []ReleaseMetadata) ([]ReleaseMetadata, error)
+}
+
+// ReleaseSelectorFunc adapts an ordinary function to the ReleaseSelector interface.
+type ReleaseSelectorFunc func(releases []ReleaseMetadata) ([]ReleaseMetadata, error)
+
+// SelectReleases calls f(releases).
+func (f ReleaseSelectorFunc) SelectReleases(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+	return f(releases)
+}
+
+// SelectVersions calls f with the metadata known from the versions alone.
+func (f ReleaseSelectorFunc) SelectVersions(versions []string) ([]string, error) {
+	selected, err := f(releasesFromVersions(versions))
+	if err != nil {
+		return nil, err
+	}
+	return releaseVersions(selected), nil
+}
+
+// ChainVersionSelectors returns a VersionSelector that applies each of the selectors in turn
+// to the versions chosen by the one before, such as SelectReleasedWithin followed by
+// SelectLatestPatchVersions.
+func ChainVersionSelectors(selectors ...VersionSelector) ReleaseSelector {
+	return ReleaseSelectorFunc(func(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+		for _, selector := range selectors {
+			var err error
+			if releases, err = selectReleasesE(selector, releases); err != nil {
+				return nil, err
+			}
+		}
+		return releases, nil
+	})
+}
+
+// SelectReleasedWithin returns a VersionSelector that tests the matching versions released
+// within the given age, such as 365 * 24 * time.Hour for those released in the last year.
+// Versions whose release date isn't known, such as providers, are all tested.
+func SelectReleasedWithin(age time.Duration) ReleaseSelector {
+	return ReleaseSelectorFunc(func(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+		since := time.Now().Add(-age)
+
+		var selected []ReleaseMetadata
+		for _, release := range releases {
+			if release.CreatedAt.IsZero() || !release.CreatedAt.Before(since) {
+				selected = append(selected, release)
+			}
+		}
+		return sortReleases(selected)
+	})
+}
+
+// SupportWindow describes which minor versions of a release are still supported.
+type SupportWindow struct {
+	// Minors is how many of the newest major.minor versions are supported, such as 3 for the
+	// current minor version and the two before it. Every minor version is when it is zero.
+	Minors int
+	// MaxAge is how long a minor version is supported for after its first release. Minor
+	// versions are supported regardless of age when it is zero, or when no release date is
+	// known for them.
+	MaxAge time.Duration
+}
+
+// HashiCorpSupportWindow returns the SupportWindow of HashiCorp's support period policy: the
+// current minor version and the two before it (N-2), for up to two years after their release.
+func HashiCorpSupportWindow() SupportWindow {
+	return SupportWindow{Minors: 3, MaxAge: 2 * 365 * 24 * time.Hour}
+}
+
+// SelectSupportWindow returns a VersionSelector that tests every matching version of the minor
+// versions still inside the support window. The newest minor versions are counted among the
+// matching versions, so a module constrained to older versions tests its newest ones.
+func SelectSupportWindow(window SupportWindow) ReleaseSelector {
+	return ReleaseSelectorFunc(func(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+		sorted, err := sortReleases(releases)
+		if err != nil {
+			return nil, err
+		}
+
+		// Group the releases by minor version, newest first.
+		var groups [][]ReleaseMetadata
+		var previous *version.Version
+		for i := len(sorted) - 1; i >= 0; i-- {
+			v, err := version.NewVersion(sorted[i].Version)
+			if err != nil {
+				return nil, err
+			}
+			if previous == nil || !sameMinorVersion(v, previous) {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append([]ReleaseMetadata{sorted[i]}, groups[len(groups)-1]...)
+			previous = v
+		}
+
+		if window.Minors > 0 && len(groups) > window.Minors {
+			groups = groups[:window.Minors]
+		}
+
+		since := time.Now().Add(-window.MaxAge)
+
+		var selected []ReleaseMetadata
+		for i := len(groups) - 1; i >= 0; i-- {
+			if released := firstReleaseDate(groups[i]); window.MaxAge > 0 && !released.IsZero() && released.Before(since) {
+				continue
+			}
+			selected = append(selected, groups[i]...)
+		}
+		return selected, nil
+	})
+}
+
+// firstReleaseDate returns the earliest known release date of the releases, or the zero value
+// if none is known.
+func firstReleaseDate(releases []ReleaseMetadata) time.Time {
+	var first time.Time
+	for _, release := range releases {
+		if !release.CreatedAt.IsZero() && (first.IsZero() || release.CreatedAt.Before(first)) {
+			first = release.CreatedAt
+		}
+	}
+	return first
+}
+
+// selectVersionsE applies the selector, if any, to the versions, whose metadata is held in
+// releases keyed by their normalised version.
+func selectVersionsE(selector VersionSelector, versions []string, releases map[string]ReleaseMetadata) ([]string, error) {
+	if selector == nil {
+		return versions, nil
+	}
+
+	selected, err := selectReleasesE(selector, releasesForVersions(versions, releases))
+	if err != nil {
+		return nil, err
+	}
+
+	normalised := make([]string, 0, len(selected))
+	for _, ver := range releaseVersions(selected) {
+		normalised = append(normalised, normaliseVersion(ver))
+	}
+	return normalised, nil
+}
+
+// selectReleasesE applies the selector to the releases, by their versions alone unless it is a
+// ReleaseSelector.
+func selectReleasesE(selector VersionSelector, releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+	if releaseSelector, ok := selector.(ReleaseSelector); ok {
+		return releaseSelector.SelectReleases(releases)
+	}
+
+	versions, err := selector.SelectVersions(releaseVersions(releases))
+	if err != nil {
+		return nil, err
+	}
+	return releasesForVersions(versions, releasesByVersion(releases)), nil
+}
+
+// sortReleases returns the releases in ascending semver order, or returns an error if any of
+// their versions is not valid.
+func sortReleases(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+	sorted, err := sortVersionStrings(releaseVersions(releases))
+	if err != nil {
+		return nil, err
+	}
+	return releasesForVersions(sorted, releasesByVersion(releases)), nil
+}
+
+// parseSortedVersions parses the given version strings and returns them in ascending order,
+// or returns an error if any of them is not a valid version.
+func parseSortedVersions(versions []string) ([]*version.Version, error) {
+	parsed := make([]*version.Version, 0, len(versions))
+	for _, v := range versions {
+		vObj, err := version.NewVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, vObj)
+	}
+
+	sort.Sort(version.Collection(parsed))
+	return parsed, nil
+}
+
+// sortVersionStrings returns the given version strings in ascending order, in their canonical
+// form.
+func sortVersionStrings(versions []string) ([]string, error) {
+	parsed, err := parseSortedVersions(versions)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]string, 0, len(parsed))
+	for _, v := range parsed {
+		sorted = append(sorted, v.String())
+	}
+	return sorted, nil
+}
+
+// sameMinorVersion reports whether both versions share the same major.minor version.
+func sameMinorVersion(a, b *version.Version) bool {
+	as, bs := a.Segments(), b.Segments()
+	return as[0] == bs[0] && as[1] == bs[1]
+}
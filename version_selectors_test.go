@@ -0,0 +1,68 @@
+package testhelpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionsAreNormalised(t *testing.T) {
+	versions := []string{"v1.6", "1.5.0", "1.5.7", "v1.4.2"}
+	want := []string{"1.4.2", "1.5.0", "1.5.7", "1.6.0"}
+
+	matching, err := GetMatchingVersionsE(">= 1.0", versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matching, want) {
+		t.Errorf("expected matching versions %v, got %v", want, matching)
+	}
+
+	selectors := []struct {
+		name     string
+		selector VersionSelector
+		want     []string
+	}{
+		{name: "all", selector: SelectAllVersions(), want: want},
+		{name: "latest patch", selector: SelectLatestPatchVersions(), want: []string{"1.4.2", "1.5.7", "1.6.0"}},
+		{name: "boundary", selector: SelectBoundaryVersions(), want: []string{"1.4.2", "1.6.0"}},
+		{
+			name: "release selector",
+			selector: ReleaseSelectorFunc(func(releases []ReleaseMetadata) ([]ReleaseMetadata, error) {
+				return []ReleaseMetadata{{Version: "v1.6"}}, nil
+			}),
+			want: []string{"1.6.0"},
+		},
+	}
+
+	for _, tt := range selectors {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectVersionsE(tt.selector, versions, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(selected, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, selected)
+			}
+		})
+	}
+
+	t.Run("blocklist", func(t *testing.T) {
+		previous := GetBlocklist()
+		t.Cleanup(func() { SetBlocklist(previous) })
+		SetBlocklist(Blocklist{"terraform": {{Version: "= 1.6.0", Reason: "broken"}}})
+
+		allowed, blocked := filterBlockedVersions("terraform", versions)
+		if want := []string{"1.5.0", "1.5.7", "1.4.2"}; !reflect.DeepEqual(allowed, want) {
+			t.Errorf("expected %v to be allowed, got %v", want, allowed)
+		}
+		if _, ok := blocked["1.6.0"]; !ok || len(blocked) != 1 {
+			t.Errorf("expected 1.6.0 to be blocked, got %v", blocked)
+		}
+	})
+
+	t.Run("pins", func(t *testing.T) {
+		if pin := newVersionPin(".terraform-version", "v1.6"); pin == nil || pin.version != "1.6.0" {
+			t.Errorf("expected the pin 1.6.0, got %+v", pin)
+		}
+	})
+}